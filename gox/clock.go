@@ -0,0 +1,139 @@
+package gox
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and tickers.
+// It allows replacing the real time, e.g. with a [FakeClock] in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTicker returns a new Ticker delivering ticks with the given period.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, see [time.Ticker].
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker. No more ticks will be sent after Stop returns.
+	Stop()
+}
+
+// RealClock is a Clock using the real time of the time package.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (rt realTicker) C() <-chan time.Time { return rt.Ticker.C }
+
+// FakeClock is a Clock whose time only changes when it's advanced manually.
+// Useful to make time-dependent behavior deterministic in tests.
+//
+// FakeClock is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock creates a new FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.now
+}
+
+// NewTicker returns a new Ticker that delivers ticks as the clock is advanced.
+// Like [time.Ticker], it drops ticks to make up for slow receivers.
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	ft := &fakeTicker{
+		fc:     fc,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   fc.now.Add(d),
+	}
+	fc.tickers = append(fc.tickers, ft)
+	return ft
+}
+
+// Advance advances the clock by d, delivering the ticks of tickers that became due.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.setLocked(fc.now.Add(d))
+}
+
+// Set sets the clock to the given time, delivering the ticks of tickers that became due.
+func (fc *FakeClock) Set(now time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.setLocked(now)
+}
+
+// setLocked sets the clock, fc.mu must be held.
+func (fc *FakeClock) setLocked(now time.Time) {
+	fc.now = now
+
+	for _, ft := range fc.tickers {
+		if ft.next.After(now) {
+			continue
+		}
+		select {
+		case ft.c <- now:
+		default: // Receiver is slow, drop tick
+		}
+		for !ft.next.After(now) {
+			ft.next = ft.next.Add(ft.period)
+		}
+	}
+}
+
+// fakeTicker is a Ticker of FakeClock.
+type fakeTicker struct {
+	fc     *FakeClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time // Guarded by fc.mu
+}
+
+func (ft *fakeTicker) C() <-chan time.Time { return ft.c }
+
+func (ft *fakeTicker) Stop() {
+	ft.fc.mu.Lock()
+	defer ft.fc.mu.Unlock()
+
+	for i, t := range ft.fc.tickers {
+		if t == ft {
+			ft.fc.tickers = append(ft.fc.tickers[:i], ft.fc.tickers[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package gox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+
+	ticker := fc.NewTicker(time.Minute)
+
+	fc.Advance(30 * time.Second)
+	if got, exp := fc.Now(), start.Add(30*time.Second); !got.Equal(exp) {
+		t.Errorf("Expected %v, got: %v", exp, got)
+	}
+	select {
+	case <-ticker.C():
+		t.Errorf("Unexpected tick")
+	default:
+	}
+
+	fc.Advance(90 * time.Second) // Due twice, but only 1 tick is buffered
+	select {
+	case got := <-ticker.C():
+		if exp := start.Add(2 * time.Minute); !got.Equal(exp) {
+			t.Errorf("Expected %v, got: %v", exp, got)
+		}
+	default:
+		t.Errorf("Expected tick")
+	}
+	select {
+	case <-ticker.C():
+		t.Errorf("Unexpected tick")
+	default:
+	}
+
+	ticker.Stop()
+	fc.Set(start.Add(time.Hour))
+	select {
+	case <-ticker.C():
+		t.Errorf("Unexpected tick after Stop")
+	default:
+	}
+}
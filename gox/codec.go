@@ -0,0 +1,43 @@
+package gox
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Encoder encodes values to an underlying stream.
+// *gob.Encoder and *json.Encoder implement this interface.
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Decoder decodes values from an underlying stream.
+// *gob.Decoder and *json.Decoder implement this interface.
+type Decoder interface {
+	Decode(v any) error
+}
+
+// Codec is a serialization format, it creates encoders and decoders operating on streams.
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+var (
+	// GobCodec is a Codec using the encoding/gob package.
+	GobCodec Codec = gobCodec{}
+
+	// JSONCodec is a Codec using the encoding/json package.
+	JSONCodec Codec = jsonCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
@@ -5,6 +5,7 @@ package gox
 import (
 	"cmp"
 	"fmt"
+	"runtime/debug"
 )
 
 // If returns vtrue if cond is true, vfalse otherwise.
@@ -192,6 +193,38 @@ func Protect(f func()) (err error) {
 	return
 }
 
+// PanicError is an error describing a recovered panic, see [ProtectStack].
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// Error returns the error message, which does not include the stack trace.
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("recovered value: %v", pe.Value)
+}
+
+// Unwrap returns the value passed to panic if it's an error, nil otherwise.
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
+
+// ProtectStack is like [Protect], but the returned error is a *[PanicError] which also includes the stack trace.
+func ProtectStack(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	f()
+	return
+}
+
 // Retry calls f and returns its results if it returns a nil error.
 // Else retries calling f up to maxRetries times, and returns its results on the first time nil error is returned.
 // If f returns non-nil error even after maxRetries calls, the final results are returned.
@@ -2,6 +2,8 @@ package gox
 
 import (
 	"context"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,14 +19,19 @@ type Evictable interface {
 //
 // [OpCache] has Evict() method, so any OpCache can be listed (does not depend on the type parameter).
 func RunEvictor(ctx context.Context, evictorPeriod time.Duration, opCaches ...Evictable) {
-	ticker := time.NewTicker(evictorPeriod)
+	RunEvictorClock(ctx, RealClock, evictorPeriod, opCaches...)
+}
+
+// RunEvictorClock is like [RunEvictor], but the eviction period is measured by the given clock.
+func RunEvictorClock(ctx context.Context, clock Clock, evictorPeriod time.Duration, opCaches ...Evictable) {
+	ticker := clock.NewTicker(evictorPeriod)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 		}
 
 		for _, oc := range opCaches {
@@ -39,44 +46,166 @@ type evictableItem struct {
 	nextEvictAt    time.Time
 }
 
-var (
-	globalEvictorMu      sync.Mutex
-	globalEvictableItems []*evictableItem
-)
+// Evictor periodically evicts registered Evictables (such as [OpCache]s), each with its own eviction period.
+// The evictor checks registered Evictables every minute, so eviction periods are measured in minutes.
+//
+// The evictor's goroutine is started on demand when the first Evictable is added,
+// and it exits when the last one is removed.
+//
+// OpCaches are registered to a global evictor by default, see [OpCacheConfig.Evictor].
+type Evictor struct {
+	mu    sync.Mutex
+	clock Clock
+	items []*evictableItem
+	stop  chan struct{} // Closing it stops the running evictor goroutine, nil if not running
+}
+
+// NewEvictor creates a new Evictor. If clock is nil, RealClock is used.
+func NewEvictor(clock Clock) *Evictor {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &Evictor{clock: clock}
+}
+
+// globalEvictor is the evictor OpCaches are added to by default.
+var globalEvictor = NewEvictor(RealClock)
+
+// SetGlobalEvictorClock sets the clock of the global evictor which evicts OpCaches
+// (unless they are created with negative [OpCacheConfig.AutoEvictPeriodMinutes] or with a custom [OpCacheConfig.Evictor]).
+// The global evictor uses [RealClock] by default.
+//
+// Scheduled evictions are rescheduled according to the new clock.
+func SetGlobalEvictorClock(clock Clock) {
+	globalEvictor.setClock(clock)
+}
+
+// setClock sets the clock of the evictor, and restarts it if it's running.
+func (e *Evictor) setClock(clock Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.clock = clock
+
+	now := clock.Now()
+	for _, item := range e.items {
+		item.nextEvictAt = now.Add(item.evictionPeriod)
+	}
+
+	if e.stop != nil {
+		close(e.stop)
+		e.start()
+	}
+}
+
+// Add adds the given Evictable to the evictor to be evicted periodically, and starts the evictor if it's not yet running.
+// If evictionPeriodMinutes is not positive, DefaultEvictPeriodMinutes is used.
+//
+// Added Evictables are referenced by the evictor (they are not garbage collected) until they are removed.
+func (e *Evictor) Add(opCache Evictable, evictionPeriodMinutes int) {
+	if evictionPeriodMinutes <= 0 {
+		evictionPeriodMinutes = DefaultEvictPeriodMinutes
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-// addToGlobalEvictor adds the given opCache to the global evictor.
-// The global evictor is started on demand and is never stopped.
-func addToGlobalEvictor(opCache Evictable, evictionPeriodMinutes int) {
 	evictionPeriod := time.Duration(evictionPeriodMinutes)*time.Minute - 5*time.Second // -5 sec to make sure we don't skip an eviction due to imprecise timing
-	item := &evictableItem{
+	e.items = append(e.items, &evictableItem{
 		opCache:        opCache,
 		evictionPeriod: evictionPeriod,
-		nextEvictAt:    time.Now().Add(evictionPeriod),
+		nextEvictAt:    e.clock.Now().Add(evictionPeriod),
+	})
+
+	if e.stop == nil {
+		// This is the first evictable opCache, launch evictor:
+		e.start()
 	}
+}
 
-	globalEvictorMu.Lock()
-	defer globalEvictorMu.Unlock()
-
-	if len(globalEvictableItems) == 0 {
-		// This is the first evictable opCache, launch global evictor:
-		go func() {
-			for now := range time.Tick(time.Minute) { // Every minute
-				globalEvictorMu.Lock()
-				var items []*evictableItem
-				for _, item := range globalEvictableItems {
-					if now.After(item.nextEvictAt) {
-						items = append(items, item)
-						item.nextEvictAt = now.Add(item.evictionPeriod)
-					}
-				}
-				globalEvictorMu.Unlock()
+// Remove removes the given Evictable from the evictor.
+// If no Evictables remain, the evictor's goroutine exits (it is restarted if a new Evictable is added).
+func (e *Evictor) Remove(opCache Evictable) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.items = slices.DeleteFunc(e.items, func(item *evictableItem) bool { return item.opCache == opCache })
+
+	if len(e.items) == 0 && e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+// Len returns the number of registered Evictables.
+func (e *Evictor) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.items)
+}
+
+// OpCaches returns the registered Evictables that are OpCaches (that implement [OpCacheInspector]),
+// sorted by name.
+func (e *Evictor) OpCaches() []OpCacheInspector {
+	e.mu.Lock()
+	var caches []OpCacheInspector
+	for _, item := range e.items {
+		if oci, ok := item.opCache.(OpCacheInspector); ok {
+			caches = append(caches, oci)
+		}
+	}
+	e.mu.Unlock()
+
+	slices.SortStableFunc(caches, func(a, b OpCacheInspector) int { return strings.Compare(a.Name(), b.Name()) })
+	return caches
+}
 
-				for _, item := range items {
-					item.opCache.Evict()
+// RegisteredOpCaches returns the OpCaches registered in the global evictor, sorted by name.
+// OpCaches are registered in the global evictor unless they are created with negative
+// [OpCacheConfig.AutoEvictPeriodMinutes] or with a custom [OpCacheConfig.Evictor].
+func RegisteredOpCaches() []OpCacheInspector {
+	return globalEvictor.OpCaches()
+}
+
+// running tells if the evictor's goroutine is running.
+func (e *Evictor) running() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.stop != nil
+}
+
+// start launches the evictor goroutine. e.mu must be held.
+func (e *Evictor) start() {
+	stop := make(chan struct{})
+	e.stop = stop
+	ticker := e.clock.NewTicker(time.Minute) // Every minute
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			var now time.Time
+			select {
+			case <-stop:
+				return
+			case now = <-ticker.C():
+			}
+
+			e.mu.Lock()
+			var items []*evictableItem
+			for _, item := range e.items {
+				if now.After(item.nextEvictAt) {
+					items = append(items, item)
+					item.nextEvictAt = now.Add(item.evictionPeriod)
 				}
 			}
-		}()
-	}
+			e.mu.Unlock()
 
-	globalEvictableItems = append(globalEvictableItems, item)
+			for _, item := range items {
+				item.opCache.Evict()
+			}
+		}
+	}()
 }
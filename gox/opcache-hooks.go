@@ -0,0 +1,36 @@
+package gox
+
+// EvictionReason tells why an entry was removed from an [OpCache], see [OpCacheConfig.OnEvict].
+type EvictionReason int
+
+const (
+	// EvictionReasonExpired tells the entry was evicted because it became invalid (expired beyond its grace period).
+	EvictionReasonExpired EvictionReason = iota
+
+	// EvictionReasonRemoved tells the entry was removed explicitly
+	// (by [OpCache.Remove], [OpCache.RemoveLocal], [OpCache.RemoveByTag] or [OpCache.RemoveFunc]).
+	EvictionReasonRemoved
+
+	// EvictionReasonCleared tells the entry was removed by [OpCache.Clear] (or [OpCache.Close]).
+	EvictionReasonCleared
+
+	// EvictionReasonCapacity tells the entry was evicted to make room for new entries
+	// (see [OpCacheConfig.MaxEntries] and [OpCacheConfig.MaxWeight]).
+	EvictionReasonCapacity
+)
+
+// evictionReasonNames holds the names of eviction reasons.
+var evictionReasonNames = [...]string{
+	EvictionReasonExpired:  "expired",
+	EvictionReasonRemoved:  "removed",
+	EvictionReasonCleared:  "cleared",
+	EvictionReasonCapacity: "capacity",
+}
+
+// String returns the name of the eviction reason, e.g. "expired".
+func (r EvictionReason) String() string {
+	if r >= 0 && int(r) < len(evictionReasonNames) {
+		return evictionReasonNames[r]
+	}
+	return "unknown"
+}
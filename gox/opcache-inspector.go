@@ -0,0 +1,73 @@
+package gox
+
+import (
+	"fmt"
+	"time"
+)
+
+// OpCacheInspector is a view of an [OpCache] independent of its type parameters,
+// so caches of different types can be listed and managed together, e.g. on debug pages.
+// *OpCache implements OpCacheInspector.
+//
+// Keys are presented in their fmt.Sprint() form.
+type OpCacheInspector interface {
+	// Name returns the name of the cache, see [OpCacheConfig.Name].
+	Name() string
+
+	// Stats returns a snapshot of the cache statistics.
+	Stats() OpCacheStats
+
+	// EntryInfos returns info of the cached entries (in no particular order).
+	EntryInfos() []OpCacheEntryInfo
+
+	// RemoveKeyString removes the entries whose keys' fmt.Sprint() form equals to key,
+	// and returns the number of removed entries.
+	RemoveKeyString(key string) int
+
+	// Clear removes all cached entries.
+	Clear()
+}
+
+// OpCacheEntryInfo describes a cached entry of an [OpCache], independent of its type parameters.
+type OpCacheEntryInfo struct {
+	// Key is the fmt.Sprint() form of the key.
+	Key string `json:"key"`
+
+	// Err is the error message of an error result, empty if the result is not an error.
+	Err string `json:"err,omitempty"`
+
+	ExpiresAt      time.Time `json:"expiresAt"`
+	GraceExpiresAt time.Time `json:"graceExpiresAt"`
+	Reloading      bool      `json:"reloading"`
+}
+
+// Name returns the name of the cache, see [OpCacheConfig.Name].
+func (oc *OpCache[K, T]) Name() string {
+	return oc.cfg.Name
+}
+
+// EntryInfos returns info of the cached entries (in no particular order).
+// It implements [OpCacheInspector].
+func (oc *OpCache[K, T]) EntryInfos() []OpCacheEntryInfo {
+	infos := make([]OpCacheEntryInfo, 0, oc.Len())
+	for key, entry := range oc.Range() {
+		info := OpCacheEntryInfo{
+			Key:            fmt.Sprint(key),
+			ExpiresAt:      entry.ExpiresAt,
+			GraceExpiresAt: entry.GraceExpiresAt,
+			Reloading:      entry.Reloading,
+		}
+		if entry.Err != nil {
+			info.Err = entry.Err.Error()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// RemoveKeyString removes the entries whose keys' fmt.Sprint() form equals to key,
+// and returns the number of removed entries. See [OpCache.RemoveFunc] for details.
+// It implements [OpCacheInspector].
+func (oc *OpCache[K, T]) RemoveKeyString(key string) int {
+	return oc.RemoveFunc(func(k K, _ OpCacheEntry[T]) bool { return fmt.Sprint(k) == key })
+}
@@ -0,0 +1,108 @@
+package gox
+
+import (
+	"iter"
+	"time"
+)
+
+// OpCacheEntry describes a cached entry of an [OpCache].
+type OpCacheEntry[T any] struct {
+	// Result and Err are the cached results of the operation.
+	Result T
+	Err    error
+
+	// ExpiresAt is the time the entry expires (after which it's reloaded if it's accessed within its grace period).
+	ExpiresAt time.Time
+
+	// GraceExpiresAt is the end of the grace period of the entry (after which it's no longer used).
+	GraceExpiresAt time.Time
+
+	// Reloading tells if a (background) reload of the entry is in progress.
+	Reloading bool
+}
+
+// IsError tells if the cached result is an error result.
+func (e OpCacheEntry[T]) IsError() bool {
+	return e.Err != nil
+}
+
+// newOpCacheEntry creates an OpCacheEntry from the given opResult.
+func newOpCacheEntry[T any](opr *opResult[T]) OpCacheEntry[T] {
+	opr.reloadMu.RLock()
+	reloading := opr.reloading
+	opr.reloadMu.RUnlock()
+
+	return OpCacheEntry[T]{
+		Result:         opr.result,
+		Err:            opr.resultErr,
+		ExpiresAt:      opr.expiresAt,
+		GraceExpiresAt: opr.graceExpiresAt,
+		Reloading:      reloading,
+	}
+}
+
+// Peek returns the cached entry of the given key, without executing any operation.
+// ok tells if the key is cached.
+//
+// Peek does not count as an access: it does not affect statistics nor the eviction policy,
+// and it does not trigger a reload of entries within their grace period.
+//
+// Note that expired entries are returned until they are evicted, check the entry's expiration times if needed.
+func (oc *OpCache[K, T]) Peek(key K) (entry OpCacheEntry[T], ok bool) {
+	opr := oc.getCachedOpResult(key)
+	if opr == nil {
+		return
+	}
+	return newOpCacheEntry(opr), true
+}
+
+// Len returns the number of cached entries (including expired entries not yet evicted).
+func (oc *OpCache[K, T]) Len() (n int) {
+	for _, sh := range oc.shards {
+		n += sh.len()
+	}
+	return
+}
+
+// Keys returns an iterator over the keys of cached entries (including expired entries not yet evicted).
+// The order of keys is unspecified.
+//
+// Keys of a shard are collected before they are yielded, so it's safe to modify the cache during iteration
+// (e.g. remove yielded keys).
+func (oc *OpCache[K, T]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for key := range oc.Range() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// Range returns an iterator over the cached entries (including expired entries not yet evicted).
+// The order of entries is unspecified.
+//
+// Like [OpCache.Peek], ranging over the entries does not count as an access.
+//
+// Entries of a shard are collected before they are yielded, so it's safe to modify the cache during iteration
+// (e.g. remove yielded keys).
+func (oc *OpCache[K, T]) Range() iter.Seq2[K, OpCacheEntry[T]] {
+	return func(yield func(K, OpCacheEntry[T]) bool) {
+		for _, sh := range oc.shards {
+			sh.keyResultsMu.RLock()
+			keys := make([]K, 0, len(sh.keyResults))
+			oprs := make([]*opResult[T], 0, len(sh.keyResults))
+			for key, opr := range sh.keyResults {
+				keys = append(keys, key)
+				oprs = append(oprs, opr)
+			}
+			sh.keyResultsMu.RUnlock()
+
+			for i, key := range keys {
+				if !yield(key, newOpCacheEntry(oprs[i])) {
+					return
+				}
+			}
+		}
+	}
+}
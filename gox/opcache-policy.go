@@ -0,0 +1,418 @@
+package gox
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+)
+
+// EvictionPolicy tells which entries to evict from an [OpCache] when it is full.
+// See [OpCacheConfig.MaxEntries].
+type EvictionPolicy int
+
+const (
+	// EvictionLRU evicts the least recently used entry.
+	EvictionLRU EvictionPolicy = iota
+
+	// EvictionLFU evicts the least frequently used entry.
+	// Among entries with the same access frequency the least recently used is evicted.
+	EvictionLFU
+
+	// EvictionTinyLFU implements W-TinyLFU: new entries enter a small LRU window,
+	// and entries leaving the window are only admitted into the main LRU area if their
+	// estimated access frequency is higher than that of the main area's eviction candidate.
+	// Access frequencies are estimated with a periodically aged count-min sketch,
+	// so keys of already evicted entries are also remembered (approximately).
+	//
+	// This policy is resistant to scans (one-off accesses of many keys) flushing out frequently used entries.
+	EvictionTinyLFU
+)
+
+// evictionPolicy tracks the keys of an OpCache and chooses eviction victims.
+// Implementations are not safe for concurrent use.
+type evictionPolicy[K comparable] interface {
+	// add records a new key.
+	add(key K)
+
+	// touch records an access of a tracked key. Untracked keys must be ignored.
+	touch(key K)
+
+	// remove stops tracking a key. Untracked keys must be ignored.
+	remove(key K)
+
+	// evict chooses a victim, stops tracking it and returns it.
+	// ok is false if no keys are tracked.
+	evict() (key K, ok bool)
+
+	// clear stops tracking all keys.
+	clear()
+}
+
+// newEvictionPolicy creates a new evictionPolicy implementing the given policy.
+// capacityHint is the expected max number of tracked keys (0 if unknown).
+func newEvictionPolicy[K comparable](policy EvictionPolicy, capacityHint int) evictionPolicy[K] {
+	switch policy {
+	case EvictionLFU:
+		return newLFUPolicy[K]()
+	case EvictionTinyLFU:
+		return newTinyLFUPolicy[K](capacityHint)
+	default:
+		return newLRUPolicy[K]()
+	}
+}
+
+// lruPolicy implements the least recently used eviction policy.
+type lruPolicy[K comparable] struct {
+	ll    *list.List // Front is the most recently used
+	elems map[K]*list.Element
+}
+
+func newLRUPolicy[K comparable]() *lruPolicy[K] {
+	return &lruPolicy[K]{
+		ll:    list.New(),
+		elems: map[K]*list.Element{},
+	}
+}
+
+func (p *lruPolicy[K]) add(key K) {
+	if e := p.elems[key]; e != nil {
+		p.ll.MoveToFront(e)
+		return
+	}
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+func (p *lruPolicy[K]) touch(key K) {
+	if e := p.elems[key]; e != nil {
+		p.ll.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy[K]) remove(key K) {
+	if e := p.elems[key]; e != nil {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lruPolicy[K]) evict() (key K, ok bool) {
+	e := p.ll.Back()
+	if e == nil {
+		return
+	}
+	key = p.ll.Remove(e).(K)
+	delete(p.elems, key)
+	return key, true
+}
+
+func (p *lruPolicy[K]) clear() {
+	p.ll.Init()
+	clear(p.elems)
+}
+
+// lfuItem is an item of lfuPolicy.
+type lfuItem[K comparable] struct {
+	key      K
+	freq     int
+	lastUsed uint64 // Value of lfuPolicy.tick when last used
+	index    int    // Index in the heap
+}
+
+// lfuPolicy implements the least frequently used eviction policy.
+// It's a min-heap ordered by frequency, and by last use if frequencies are equal.
+type lfuPolicy[K comparable] struct {
+	items []*lfuItem[K]
+	elems map[K]*lfuItem[K]
+	tick  uint64
+}
+
+func newLFUPolicy[K comparable]() *lfuPolicy[K] {
+	return &lfuPolicy[K]{
+		elems: map[K]*lfuItem[K]{},
+	}
+}
+
+func (p *lfuPolicy[K]) Len() int { return len(p.items) }
+
+func (p *lfuPolicy[K]) Less(i, j int) bool {
+	if p.items[i].freq != p.items[j].freq {
+		return p.items[i].freq < p.items[j].freq
+	}
+	return p.items[i].lastUsed < p.items[j].lastUsed
+}
+
+func (p *lfuPolicy[K]) Swap(i, j int) {
+	p.items[i], p.items[j] = p.items[j], p.items[i]
+	p.items[i].index = i
+	p.items[j].index = j
+}
+
+func (p *lfuPolicy[K]) Push(x any) {
+	item := x.(*lfuItem[K])
+	item.index = len(p.items)
+	p.items = append(p.items, item)
+}
+
+func (p *lfuPolicy[K]) Pop() any {
+	last := len(p.items) - 1
+	item := p.items[last]
+	p.items[last] = nil
+	p.items = p.items[:last]
+	return item
+}
+
+func (p *lfuPolicy[K]) add(key K) {
+	if p.elems[key] != nil {
+		p.touch(key)
+		return
+	}
+	p.tick++
+	item := &lfuItem[K]{key: key, freq: 1, lastUsed: p.tick}
+	p.elems[key] = item
+	heap.Push(p, item)
+}
+
+func (p *lfuPolicy[K]) touch(key K) {
+	if item := p.elems[key]; item != nil {
+		p.tick++
+		item.freq++
+		item.lastUsed = p.tick
+		heap.Fix(p, item.index)
+	}
+}
+
+func (p *lfuPolicy[K]) remove(key K) {
+	if item := p.elems[key]; item != nil {
+		heap.Remove(p, item.index)
+		delete(p.elems, key)
+	}
+}
+
+func (p *lfuPolicy[K]) evict() (key K, ok bool) {
+	if len(p.items) == 0 {
+		return
+	}
+	item := heap.Pop(p).(*lfuItem[K])
+	delete(p.elems, item.key)
+	return item.key, true
+}
+
+func (p *lfuPolicy[K]) clear() {
+	p.items = nil
+	clear(p.elems)
+}
+
+// tinyLFUPolicy implements the W-TinyLFU eviction policy.
+type tinyLFUPolicy[K comparable] struct {
+	window *list.List // LRU window of new entries, front is the most recently used
+	main   *list.List // Main LRU area, front is the most recently used
+	elems  map[K]*list.Element
+
+	// fullLen is the capacity hint, or the number of tracked keys after the last eviction
+	// (0 if unknown and there were no evictions yet).
+	// Used to tell if new keys have room in the main area.
+	fullLen  int
+	capacity int // Capacity hint, 0 if unknown
+
+	sketch *countMinSketch
+	seed   maphash.Seed
+}
+
+// tinyLFUItem is a list element value of tinyLFUPolicy.
+type tinyLFUItem[K comparable] struct {
+	key      K
+	inWindow bool
+}
+
+// defaultTinyLFUCapacity is the capacity used to size the frequency sketch if capacity is unknown.
+const defaultTinyLFUCapacity = 1024
+
+func newTinyLFUPolicy[K comparable](capacityHint int) *tinyLFUPolicy[K] {
+	capacityHint = ForceMin(capacityHint, 0)
+	return &tinyLFUPolicy[K]{
+		window:   list.New(),
+		main:     list.New(),
+		elems:    map[K]*list.Element{},
+		fullLen:  capacityHint,
+		capacity: capacityHint,
+		sketch:   newCountMinSketch(Coalesce(capacityHint, defaultTinyLFUCapacity)),
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+func (p *tinyLFUPolicy[K]) hash(key K) uint64 {
+	return maphash.Comparable(p.seed, key)
+}
+
+func (p *tinyLFUPolicy[K]) add(key K) {
+	if p.elems[key] != nil {
+		p.touch(key)
+		return
+	}
+	p.sketch.increment(p.hash(key))
+	p.elems[key] = p.window.PushFront(&tinyLFUItem[K]{key: key, inWindow: true})
+
+	if p.fullLen == 0 || len(p.elems) <= p.fullLen {
+		// There is room, entries leaving the window are admitted into the main area without a fight:
+		for p.window.Len() > p.windowCap() {
+			p.moveToMain(p.window.Back())
+		}
+	}
+}
+
+// moveToMain moves the given window element to the front of the main area.
+func (p *tinyLFUPolicy[K]) moveToMain(e *list.Element) {
+	item := p.window.Remove(e).(*tinyLFUItem[K])
+	item.inWindow = false
+	p.elems[item.key] = p.main.PushFront(item)
+}
+
+func (p *tinyLFUPolicy[K]) touch(key K) {
+	e := p.elems[key]
+	if e == nil {
+		return
+	}
+	p.sketch.increment(p.hash(key))
+	if e.Value.(*tinyLFUItem[K]).inWindow {
+		p.window.MoveToFront(e)
+	} else {
+		p.main.MoveToFront(e)
+	}
+}
+
+func (p *tinyLFUPolicy[K]) remove(key K) {
+	e := p.elems[key]
+	if e == nil {
+		return
+	}
+	if e.Value.(*tinyLFUItem[K]).inWindow {
+		p.window.Remove(e)
+	} else {
+		p.main.Remove(e)
+	}
+	delete(p.elems, key)
+}
+
+// windowCap returns the current desired capacity of the window: 1% of all tracked keys.
+func (p *tinyLFUPolicy[K]) windowCap() int {
+	return ForceMin(len(p.elems)/100, 1)
+}
+
+func (p *tinyLFUPolicy[K]) evict() (key K, ok bool) {
+	defer func() {
+		p.fullLen = len(p.elems)
+	}()
+
+	for p.window.Len() > p.windowCap() {
+		candidateElem := p.window.Back()
+		victimElem := p.main.Back()
+		if victimElem == nil {
+			// Main area is empty, admit candidate without eviction:
+			p.moveToMain(candidateElem)
+			continue
+		}
+
+		candidate := candidateElem.Value.(*tinyLFUItem[K])
+		victim := victimElem.Value.(*tinyLFUItem[K])
+		if p.sketch.estimate(p.hash(candidate.key)) > p.sketch.estimate(p.hash(victim.key)) {
+			// Candidate is admitted, victim is evicted:
+			p.moveToMain(candidateElem)
+			return p.removeElem(p.main, victimElem), true
+		}
+		// Candidate is rejected:
+		return p.removeElem(p.window, candidateElem), true
+	}
+
+	// Window is within its capacity, evict from the main area (or from the window if main is empty).
+	if e := p.main.Back(); e != nil {
+		return p.removeElem(p.main, e), true
+	}
+	if e := p.window.Back(); e != nil {
+		return p.removeElem(p.window, e), true
+	}
+	return
+}
+
+// removeElem removes e from l, stops tracking its key, and returns the key.
+func (p *tinyLFUPolicy[K]) removeElem(l *list.List, e *list.Element) K {
+	key := l.Remove(e).(*tinyLFUItem[K]).key
+	delete(p.elems, key)
+	return key
+}
+
+func (p *tinyLFUPolicy[K]) clear() {
+	p.window.Init()
+	p.main.Init()
+	clear(p.elems)
+	p.fullLen = p.capacity
+	p.sketch.reset()
+}
+
+// countMinSketch is a probabilistic frequency counter with periodic aging.
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int // Number of increments since the last aging
+	sampleMax int // Counters are halved when additions reaches this
+}
+
+// countMinSketchMaxCount is the maximum value of a counter.
+const countMinSketchMaxCount = 15
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width *= 2
+	}
+	s := &countMinSketch{
+		mask:      uint64(width - 1),
+		sampleMax: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter index for the given hash in the given row.
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	h += uint64(row) * 0x9e3779b97f4a7c15
+	h ^= h >> 31
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 29
+	return h & s.mask
+}
+
+func (s *countMinSketch) increment(h uint64) {
+	for i := range s.rows {
+		if idx := s.index(h, i); s.rows[i][idx] < countMinSketchMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleMax {
+		// Age: halve all counters so old popularity fades away.
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] /= 2
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *countMinSketch) estimate(h uint64) uint8 {
+	est := uint8(countMinSketchMaxCount)
+	for i := range s.rows {
+		est = ForceMax(est, s.rows[i][s.index(h, i)])
+	}
+	return est
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		clear(s.rows[i])
+	}
+	s.additions = 0
+}
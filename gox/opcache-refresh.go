@@ -0,0 +1,128 @@
+package gox
+
+import (
+	"context"
+	"time"
+)
+
+// startRefresher starts the goroutine that refreshes entries ahead of their expiration.
+// See OpCacheConfig.RefreshAhead.
+func (oc *OpCache[K, T]) startRefresher() {
+	oc.refreshSem = make(chan struct{}, ForceMin(oc.cfg.RefreshConcurrency, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	oc.stopRefresh = cancel
+
+	ticker := oc.cfg.Clock.NewTicker(ForceMin(oc.cfg.RefreshAhead/2, time.Millisecond))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				oc.refresh(ctx)
+			}
+		}
+	}()
+}
+
+// WithLoader sets the function to load the result of a key, used to refresh entries, and returns oc.
+// Entries not having a loader of their own (e.g. entries loaded by [OpCache.MultiGet] or cached by [OpCache.Set])
+// are only refreshed if a loader is set. See [OpCacheConfig.RefreshAhead].
+//
+// Typically called right after creating the cache:
+//
+//	opc := NewOpCache[int, *User](cfg).WithLoader(loadUser)
+func (oc *OpCache[K, T]) WithLoader(loader func(ctx context.Context, key K) (result T, err error)) *OpCache[K, T] {
+	oc.loader.Store(&loader)
+	return oc
+}
+
+// accessed records a read of opr at the given time if refreshing is enabled.
+func (oc *OpCache[K, T]) accessed(opr *opResult[T], now time.Time) {
+	if oc.refreshSem != nil {
+		opr.accessed(now)
+	}
+}
+
+// accessed records a read at the given time (unless a later read is already recorded).
+func (opr *opResult[T]) accessed(t time.Time) {
+	nanos := t.UnixNano()
+	for {
+		last := opr.lastAccess.Load()
+		if last >= nanos || opr.lastAccess.CompareAndSwap(last, nanos) {
+			return
+		}
+	}
+}
+
+// refreshItem is an entry to be refreshed.
+type refreshItem[K comparable, T any] struct {
+	key K
+	opr *opResult[T]
+}
+
+// refresh launches the refresh of entries that are due (are about to expire),
+// limited by OpCacheConfig.RefreshConcurrency.
+func (oc *OpCache[K, T]) refresh(ctx context.Context) {
+	now := oc.cfg.Clock.Now()
+	idlePeriod := Coalesce(oc.cfg.RefreshIdlePeriod, oc.cfg.ResultExpiration)
+	cacheLoader := oc.loader.Load()
+
+	var due []refreshItem[K, T]
+	for _, sh := range oc.shards {
+		sh.keyResultsMu.RLock()
+		for key, opr := range sh.keyResults {
+			if !now.Before(opr.expiresAt.Add(-oc.cfg.RefreshAhead)) && opr.graceValid(now) &&
+				now.Sub(time.Unix(0, opr.lastAccess.Load())) < idlePeriod &&
+				(opr.loader != nil || cacheLoader != nil) {
+				due = append(due, refreshItem[K, T]{key, opr})
+			}
+		}
+		sh.keyResultsMu.RUnlock()
+	}
+
+	for _, item := range due {
+		select {
+		case oc.refreshSem <- struct{}{}:
+		default:
+			return // Limit reached, remaining entries are refreshed later (if still due)
+		}
+		if !item.opr.claimReload() {
+			<-oc.refreshSem // Someone's already reloading it
+			continue
+		}
+
+		oc.shard(item.key).inc(counterReloads, 1)
+		go func() {
+			defer func() { <-oc.refreshSem }()
+			oc.refreshEntry(ctx, item.key, item.opr, cacheLoader)
+		}()
+	}
+}
+
+// refreshEntry reloads the entry opr of key.
+// cacheLoader is the loader of the cache (see WithLoader), used if the entry has no loader of its own.
+func (oc *OpCache[K, T]) refreshEntry(ctx context.Context, key K, opr *opResult[T], cacheLoader *func(ctx context.Context, key K) (T, error)) {
+	loader := opr.loader
+	if loader == nil {
+		loader = func(ctx context.Context) (result T, opts EntryOptions, err error) {
+			result, err = (*cacheLoader)(ctx, key)
+			return result, EntryOptions{Tags: opr.tags}, err
+		}
+	}
+
+	execOp := loader
+	if oc.cfg.Store != nil {
+		// Another instance may have already refreshed it: use the stored entry if it's fresher than ours.
+		execOp = func(ctx context.Context) (T, EntryOptions, error) {
+			if result, opts, ok := oc.storeGet(ctx, key); ok && oc.cfg.Clock.Now().Add(*opts.Expiration).After(opr.expiresAt) {
+				return result, opts, nil
+			}
+			return loader(ctx)
+		}
+	}
+
+	oc.reload(ctx, key, opr, execOp, opr.loader)
+}
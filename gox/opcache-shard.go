@@ -0,0 +1,369 @@
+package gox
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// opCacheShard holds a subset of the entries of an OpCache, with its own locks.
+// Keys are distributed among shards by their hash, see OpCache.shard.
+type opCacheShard[K comparable, T any] struct {
+	keyResultsMu sync.RWMutex
+	keyResults   map[K]*opResult[T]
+	tagKeys      map[string]map[K]struct{} // Keys of entries by tags, guarded by keyResultsMu
+	weight       int64                     // Total weight of entries, guarded by keyResultsMu
+	cacheWeight  *atomic.Int64             // Total weight of entries of all shards of the cache
+
+	// Ongoing op executions (first loads) of keys.
+	// Using a simple Mutex (instead of RWMutex) for this one, as the majority of cases will not be a concurrent Get() with same key.
+	// Code is simpler and faster for the majority of cases (for the very rare it's still correct but may be slower a bit).
+	keyCallsMu sync.Mutex
+	keyCalls   map[K]*opCall[T]
+
+	// policy is non-nil only if maxEntries or maxWeight is set.
+	// Lock order: keyResultsMu before policyMu.
+	policyMu   sync.Mutex
+	policy     evictionPolicy[K]
+	maxEntries int
+	maxWeight  int64 // Max total weight of the cache (not of the shard), see OpCache.enforceMaxWeight
+
+	counters opCacheCounters
+	metrics  OpCacheMetrics
+
+	onHit, onGraceHit func(key any)
+	onEvict           func(key any, reason EvictionReason)
+}
+
+// newOpCacheShard creates a new opCacheShard.
+// maxEntries is the max number of entries of the shard, 0 if not limited.
+// cacheWeight is the total weight of entries of all shards of the cache, maintained by the shards.
+func newOpCacheShard[K comparable, T any](cfg OpCacheConfig, maxEntries int, cacheWeight *atomic.Int64) *opCacheShard[K, T] {
+	sh := &opCacheShard[K, T]{
+		keyResults:  map[K]*opResult[T]{},
+		tagKeys:     map[string]map[K]struct{}{},
+		keyCalls:    map[K]*opCall[T]{},
+		maxEntries:  maxEntries,
+		maxWeight:   cfg.MaxWeight,
+		cacheWeight: cacheWeight,
+		metrics:     cfg.Metrics,
+		onHit:       cfg.OnHit,
+		onGraceHit:  cfg.OnGraceHit,
+		onEvict:     cfg.OnEvict,
+	}
+
+	if maxEntries > 0 || cfg.MaxWeight > 0 {
+		sh.policy = newEvictionPolicy[K](cfg.EvictionPolicy, maxEntries)
+	}
+
+	return sh
+}
+
+// shard returns the shard of the given key.
+func (oc *OpCache[K, T]) shard(key K) *opCacheShard[K, T] {
+	if len(oc.shards) == 1 {
+		return oc.shards[0]
+	}
+
+	var h uint64
+	if oc.cfg.KeyHasher != nil {
+		h = oc.cfg.KeyHasher(key)
+	} else {
+		h = maphash.Comparable(oc.seed, key)
+	}
+	return oc.shards[h%uint64(len(oc.shards))]
+}
+
+// inc increments the given counter by delta, and reports it to the metrics hook if provided.
+func (sh *opCacheShard[K, T]) inc(counter opCacheCounter, delta int64) {
+	sh.counters[counter].Add(delta)
+	if sh.metrics != nil {
+		sh.metrics.Add(opCacheCounterNames[counter], delta)
+	}
+}
+
+func (sh *opCacheShard[K, T]) get(key K) *opResult[T] {
+	sh.keyResultsMu.RLock()
+	defer sh.keyResultsMu.RUnlock()
+
+	return sh.keyResults[key]
+}
+
+func (sh *opCacheShard[K, T]) set(key K, opResults *opResult[T]) {
+	var evicted []K
+	defer func() { sh.notifyEvict(evicted, EvictionReasonCapacity) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	old, exists := sh.deleteLocked(key)
+	if exists {
+		// The last read of the key is kept if the new entry was not read yet:
+		opResults.lastAccess.CompareAndSwap(0, old.lastAccess.Load())
+	}
+	if sh.maxWeight > 0 && opResults.weight > sh.maxWeight {
+		// Too heavy to be kept, don't evict others to make room for it:
+		if exists {
+			sh.untrack(key)
+		}
+		sh.inc(counterEvictions, 1)
+		evicted = append(evicted, key)
+		return
+	}
+	sh.keyResults[key] = opResults
+	sh.weight += opResults.weight
+	sh.cacheWeight.Add(opResults.weight)
+	for _, tag := range opResults.tags {
+		keys := sh.tagKeys[tag]
+		if keys == nil {
+			keys = map[K]struct{}{}
+			sh.tagKeys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	if sh.policy == nil {
+		return
+	}
+
+	sh.policyMu.Lock()
+	defer sh.policyMu.Unlock()
+
+	if exists {
+		sh.policy.touch(key)
+	} else {
+		sh.policy.add(key)
+	}
+	for sh.overCapacity() {
+		evictKey, ok := sh.policy.evict()
+		if !ok {
+			break
+		}
+		sh.deleteLocked(evictKey)
+		sh.inc(counterEvictions, 1)
+		evicted = append(evicted, evictKey)
+	}
+}
+
+// overCapacity tells if the shard holds more entries than allowed.
+// The weight limit is enforced across shards, see OpCache.enforceMaxWeight.
+// keyResultsMu must be held.
+func (sh *opCacheShard[K, T]) overCapacity() bool {
+	return sh.maxEntries > 0 && len(sh.keyResults) > sh.maxEntries
+}
+
+// evictOne evicts an entry chosen by the eviction policy to make room for new entries.
+// Returns false if there was nothing to evict.
+func (sh *opCacheShard[K, T]) evictOne() bool {
+	if sh.policy == nil {
+		return false
+	}
+
+	sh.keyResultsMu.Lock()
+	sh.policyMu.Lock()
+	key, ok := sh.policy.evict()
+	sh.policyMu.Unlock()
+	if ok {
+		sh.deleteLocked(key)
+		sh.inc(counterEvictions, 1)
+	}
+	sh.keyResultsMu.Unlock()
+
+	if ok {
+		sh.notifyEvict([]K{key}, EvictionReasonCapacity)
+	}
+	return ok
+}
+
+// deleteLocked deletes the entry of the given key (but does not untrack it from the eviction policy).
+// Returns the deleted entry, ok tells if the key was cached.
+// keyResultsMu must be held.
+func (sh *opCacheShard[K, T]) deleteLocked(key K) (opr *opResult[T], ok bool) {
+	opr, ok = sh.keyResults[key]
+	if !ok {
+		return
+	}
+
+	delete(sh.keyResults, key)
+	sh.weight -= opr.weight
+	sh.cacheWeight.Add(-opr.weight)
+	for _, tag := range opr.tags {
+		if keys := sh.tagKeys[tag]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(sh.tagKeys, tag)
+			}
+		}
+	}
+	return
+}
+
+// hit records an access of the given key served by a valid cached result.
+func (sh *opCacheShard[K, T]) hit(key K) {
+	sh.touch(key)
+	sh.inc(counterHits, 1)
+	if sh.onHit != nil {
+		sh.onHit(key)
+	}
+}
+
+// graceHit records an access of the given key served by a grace-valid cached result.
+func (sh *opCacheShard[K, T]) graceHit(key K) {
+	sh.touch(key)
+	sh.inc(counterGraceHits, 1)
+	if sh.onGraceHit != nil {
+		sh.onGraceHit(key)
+	}
+}
+
+// notifyEvict calls the OnEvict hook (if provided) for the given keys.
+// Must be called without holding keyResultsMu, so the hook may use the cache.
+func (sh *opCacheShard[K, T]) notifyEvict(keys []K, reason EvictionReason) {
+	if sh.onEvict == nil {
+		return
+	}
+	for _, key := range keys {
+		sh.onEvict(key, reason)
+	}
+}
+
+// touch records an access of the given key for the eviction policy.
+func (sh *opCacheShard[K, T]) touch(key K) {
+	if sh.policy == nil {
+		return
+	}
+
+	sh.policyMu.Lock()
+	sh.policy.touch(key)
+	sh.policyMu.Unlock()
+}
+
+// untrack removes the given keys from the eviction policy.
+// keyResultsMu must be held.
+func (sh *opCacheShard[K, T]) untrack(keys ...K) {
+	if sh.policy == nil {
+		return
+	}
+
+	sh.policyMu.Lock()
+	for _, key := range keys {
+		sh.policy.remove(key)
+	}
+	sh.policyMu.Unlock()
+}
+
+// evict removes entries that are invalid at the given time.
+func (sh *opCacheShard[K, T]) evict(now time.Time) {
+	var evicted []K
+	defer func() { sh.notifyEvict(evicted, EvictionReasonExpired) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	for key, opResult := range sh.keyResults {
+		if !opResult.graceValid(now) { // Delete if not even grace-valid
+			sh.deleteLocked(key)
+			sh.untrack(key)
+			sh.inc(counterEvictions, 1)
+			evicted = append(evicted, key)
+		}
+	}
+}
+
+// clear removes all entries.
+func (sh *opCacheShard[K, T]) clear() {
+	var cleared []K
+	defer func() { sh.notifyEvict(cleared, EvictionReasonCleared) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	if sh.onEvict != nil {
+		for key := range sh.keyResults {
+			cleared = append(cleared, key)
+		}
+	}
+	clear(sh.keyResults)
+	clear(sh.tagKeys)
+	sh.cacheWeight.Add(-sh.weight)
+	sh.weight = 0
+
+	if sh.policy != nil {
+		sh.policyMu.Lock()
+		sh.policy.clear()
+		sh.policyMu.Unlock()
+	}
+}
+
+// remove removes the entries of the given keys, and returns the removed keys (that were cached).
+func (sh *opCacheShard[K, T]) remove(keys ...K) (removed []K) {
+	defer func() { sh.notifyEvict(removed, EvictionReasonRemoved) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	return sh.removeLocked(keys...)
+}
+
+// removeLocked removes the entries of the given keys, and returns the removed keys (that were cached).
+// keyResultsMu must be held.
+func (sh *opCacheShard[K, T]) removeLocked(keys ...K) (removed []K) {
+	for _, key := range keys {
+		if _, ok := sh.deleteLocked(key); ok {
+			removed = append(removed, key)
+		}
+	}
+	sh.untrack(keys...)
+	return
+}
+
+// removeByTags removes the entries having any of the given tags, and returns the removed keys.
+func (sh *opCacheShard[K, T]) removeByTags(tags ...string) (removed []K) {
+	defer func() { sh.notifyEvict(removed, EvictionReasonRemoved) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	for _, tag := range tags {
+		// Collect keys first, removal modifies tagKeys:
+		var keys []K
+		for key := range sh.tagKeys[tag] {
+			keys = append(keys, key)
+		}
+		removed = append(removed, sh.removeLocked(keys...)...)
+	}
+	return
+}
+
+// removeFunc removes the entries for which f returns true, and returns the removed keys.
+func (sh *opCacheShard[K, T]) removeFunc(f func(key K, entry OpCacheEntry[T]) bool) (removed []K) {
+	defer func() { sh.notifyEvict(removed, EvictionReasonRemoved) }() // Called after unlocking
+
+	sh.keyResultsMu.Lock()
+	defer sh.keyResultsMu.Unlock()
+
+	var keys []K
+	for key, opr := range sh.keyResults {
+		if f(key, newOpCacheEntry(opr)) {
+			keys = append(keys, key)
+		}
+	}
+	return sh.removeLocked(keys...)
+}
+
+// len returns the number of entries.
+func (sh *opCacheShard[K, T]) len() int {
+	sh.keyResultsMu.RLock()
+	defer sh.keyResultsMu.RUnlock()
+
+	return len(sh.keyResults)
+}
+
+// totalWeight returns the total weight of the entries.
+func (sh *opCacheShard[K, T]) totalWeight() int64 {
+	sh.keyResultsMu.RLock()
+	defer sh.keyResultsMu.RUnlock()
+
+	return sh.weight
+}
@@ -0,0 +1,109 @@
+package gox
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// opCacheSnapshotVersion is the version of the snapshot format written by OpCache.Snapshot.
+// Version 2 added entry tags (version 1 snapshots can still be restored, without tags).
+const opCacheSnapshotVersion = 2
+
+// opCacheSnapshotHeader is the first value of an OpCache snapshot.
+type opCacheSnapshotHeader struct {
+	Version int
+	Count   int // Number of entries following the header
+}
+
+// opCacheSnapshotEntry is an entry of an OpCache snapshot.
+type opCacheSnapshotEntry[K comparable, T any] struct {
+	Key            K
+	Result         T
+	ExpiresAt      time.Time
+	GraceExpiresAt time.Time
+	Tags           []string
+}
+
+// Snapshot writes the cached entries to w using the given codec, so they can be restored
+// later (e.g. after a restart) using [OpCache.Restore].
+// Returns the number of written entries.
+//
+// Only entries that are valid or within their grace period are written.
+// Error results are not written, as errors cannot be encoded in general.
+//
+// The key and result types must be encodable by the codec (e.g. using [GobCodec] or [JSONCodec]
+// they must have exported fields). Struct key types such as [Struct2] are supported.
+func (oc *OpCache[K, T]) Snapshot(w io.Writer, codec Codec) (n int, err error) {
+	var entries []opCacheSnapshotEntry[K, T]
+
+	now := oc.cfg.Clock.Now()
+	for _, sh := range oc.shards {
+		sh.keyResultsMu.RLock()
+		for key, opr := range sh.keyResults {
+			if opr.resultErr == nil && opr.graceValid(now) {
+				entries = append(entries, opCacheSnapshotEntry[K, T]{
+					Key:            key,
+					Result:         opr.result,
+					ExpiresAt:      opr.expiresAt,
+					GraceExpiresAt: opr.graceExpiresAt,
+					Tags:           opr.tags,
+				})
+			}
+		}
+		sh.keyResultsMu.RUnlock()
+	}
+
+	enc := codec.NewEncoder(w)
+	if err = enc.Encode(opCacheSnapshotHeader{Version: opCacheSnapshotVersion, Count: len(entries)}); err != nil {
+		return 0, fmt.Errorf("failed to encode snapshot header: %w", err)
+	}
+	for _, entry := range entries {
+		if err = enc.Encode(entry); err != nil {
+			return n, fmt.Errorf("failed to encode snapshot entry: %w", err)
+		}
+		n++
+	}
+
+	return
+}
+
+// Restore reads entries from r written by [OpCache.Snapshot] using the same codec,
+// and caches them with their original expiration times and tags.
+// Returns the number of restored entries.
+//
+// Entries that are no longer valid nor within their grace period (e.g. they expired while the process was down)
+// are dropped. Entries whose key is already cached are skipped (the cached entry is considered fresher).
+func (oc *OpCache[K, T]) Restore(r io.Reader, codec Codec) (n int, err error) {
+	dec := codec.NewDecoder(r)
+
+	var header opCacheSnapshotHeader
+	if err = dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot header: %w", err)
+	}
+	if header.Version < 1 || header.Version > opCacheSnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version: %d", header.Version)
+	}
+
+	for range header.Count {
+		var entry opCacheSnapshotEntry[K, T]
+		if err = dec.Decode(&entry); err != nil {
+			return n, fmt.Errorf("failed to decode snapshot entry: %w", err)
+		}
+
+		opr := &opResult[T]{
+			expiresAt:      entry.ExpiresAt,
+			graceExpiresAt: entry.GraceExpiresAt,
+			result:         entry.Result,
+			tags:           entry.Tags,
+			weight:         oc.weigh(entry.Key, entry.Result),
+		}
+		if !opr.graceValid(oc.cfg.Clock.Now()) || oc.getCachedOpResult(entry.Key) != nil {
+			continue
+		}
+		oc.setCachedOpResult(entry.Key, opr)
+		n++
+	}
+
+	return
+}
@@ -0,0 +1,137 @@
+package gox
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+)
+
+// OpCacheStats is a snapshot of the statistics of an [OpCache].
+type OpCacheStats struct {
+	// Hits is the number of lookups served by a valid cached result.
+	Hits int64 `json:"hits"`
+
+	// GraceHits is the number of lookups served by a cached result within its grace period.
+	GraceHits int64 `json:"graceHits"`
+
+	// Misses is the number of lookups that had to wait for an op execution.
+	Misses int64 `json:"misses"`
+
+	// Reloads is the number of background reloads launched (of results within their grace period).
+	Reloads int64 `json:"reloads"`
+
+	// DiscardedErrors is the number of error results not cached (see [OpCacheConfig.ErrorExpiration]).
+	DiscardedErrors int64 `json:"discardedErrors"`
+
+	// Evictions is the number of entries evicted because they became invalid,
+	// or to make room for new entries (see [OpCacheConfig.MaxEntries] and [OpCacheConfig.MaxWeight]).
+	// Entries removed explicitly (by [OpCache.Remove] or [OpCache.Clear]) are not counted.
+	Evictions int64 `json:"evictions"`
+
+	// Entries is the current number of cached entries.
+	Entries int `json:"entries"`
+
+	// Weight is the current total weight of cached entries (see [OpCache.WithWeigher]).
+	Weight int64 `json:"weight"`
+
+	// InFlight is the number of keys currently being loaded (by op executions, including background reloads).
+	InFlight int `json:"inFlight"`
+}
+
+// OpCacheMetrics is an optional hook to receive counter changes of an [OpCache] as they happen,
+// see [OpCacheConfig.Metrics].
+//
+// Key is the name of a counter of [OpCacheStats] as in its JSON encoding (camel case), e.g. "hits" or "graceHits".
+// Note that Prometheus metrics are named following Prometheus conventions instead, see [WriteOpCacheStatsPrometheus].
+//
+// *expvar.Map implements this interface, so counters can be published via expvar directly.
+type OpCacheMetrics interface {
+	Add(key string, delta int64)
+}
+
+// opCacheCounter identifies a counter of OpCache.
+type opCacheCounter int
+
+const (
+	counterHits opCacheCounter = iota
+	counterGraceHits
+	counterMisses
+	counterReloads
+	counterDiscardedErrors
+	counterEvictions
+
+	numOpCacheCounters // This must be the last one
+)
+
+// opCacheCounterNames holds the names of counters, indexed by opCacheCounter.
+var opCacheCounterNames = [numOpCacheCounters]string{
+	counterHits:            "hits",
+	counterGraceHits:       "graceHits",
+	counterMisses:          "misses",
+	counterReloads:         "reloads",
+	counterDiscardedErrors: "discardedErrors",
+	counterEvictions:       "evictions",
+}
+
+// opCacheCounters holds the counters of an OpCache (shard).
+type opCacheCounters [numOpCacheCounters]atomic.Int64
+
+// Stats returns a snapshot of the cache statistics.
+func (oc *OpCache[K, T]) Stats() OpCacheStats {
+	var stats OpCacheStats
+	for _, sh := range oc.shards {
+		stats.Hits += sh.counters[counterHits].Load()
+		stats.GraceHits += sh.counters[counterGraceHits].Load()
+		stats.Misses += sh.counters[counterMisses].Load()
+		stats.Reloads += sh.counters[counterReloads].Load()
+		stats.DiscardedErrors += sh.counters[counterDiscardedErrors].Load()
+		stats.Evictions += sh.counters[counterEvictions].Load()
+		stats.Entries += sh.len()
+		stats.Weight += sh.totalWeight()
+	}
+	stats.InFlight = int(oc.inFlight.Load())
+
+	return stats
+}
+
+// WriteOpCacheStatsPrometheus writes the given stats in Prometheus text exposition format to w.
+// Keys of namedStats are cache names, which are written as the value of the "cache" label.
+// Counters are written with a "gox_opcache_" prefix and a "_total" suffix (e.g. gox_opcache_hits_total),
+// Entries, Weight and InFlight are written as gauges (gox_opcache_entries, gox_opcache_weight and gox_opcache_in_flight).
+func WriteOpCacheStatsPrometheus(w io.Writer, namedStats map[string]OpCacheStats) error {
+	names := make([]string, 0, len(namedStats))
+	for name := range namedStats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	type metric struct {
+		name, typ, help string
+		value           func(s OpCacheStats) int64
+	}
+	metrics := []metric{
+		{"hits_total", "counter", "Number of lookups served by a valid cached result.", func(s OpCacheStats) int64 { return s.Hits }},
+		{"grace_hits_total", "counter", "Number of lookups served by a cached result within its grace period.", func(s OpCacheStats) int64 { return s.GraceHits }},
+		{"misses_total", "counter", "Number of lookups that had to wait for an op execution.", func(s OpCacheStats) int64 { return s.Misses }},
+		{"reloads_total", "counter", "Number of background reloads launched.", func(s OpCacheStats) int64 { return s.Reloads }},
+		{"discarded_errors_total", "counter", "Number of error results not cached.", func(s OpCacheStats) int64 { return s.DiscardedErrors }},
+		{"evictions_total", "counter", "Number of evicted entries.", func(s OpCacheStats) int64 { return s.Evictions }},
+		{"entries", "gauge", "Current number of cached entries.", func(s OpCacheStats) int64 { return int64(s.Entries) }},
+		{"weight", "gauge", "Current total weight of cached entries.", func(s OpCacheStats) int64 { return s.Weight }},
+		{"in_flight", "gauge", "Number of keys currently being loaded.", func(s OpCacheStats) int64 { return int64(s.InFlight) }},
+	}
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP gox_opcache_%s %s\n# TYPE gox_opcache_%s %s\n", m.name, m.help, m.name, m.typ); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "gox_opcache_%s{cache=%q} %d\n", m.name, name, m.value(namedStats[name])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
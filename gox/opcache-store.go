@@ -0,0 +1,353 @@
+package gox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OpCacheStore is a second-tier store of an [OpCache], typically a remote store shared by
+// multiple service instances (e.g. Redis). See [OpCacheConfig.Store].
+//
+// Implementations must be safe for concurrent use.
+type OpCacheStore interface {
+	// Get returns the value stored under key.
+	// found tells if the key was found (and its value is not expired).
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+
+	// Set stores value under key, which expires after the given expiration.
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+
+	// Delete deletes the values of the given keys. Keys not found are ignored.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// opCacheStoreEntry is the value an OpCache stores in its OpCacheStore (encoded using the store codec).
+type opCacheStoreEntry[T any] struct {
+	Result         T
+	ExpiresAt      time.Time
+	GraceExpiresAt time.Time
+	Tags           []string
+}
+
+// storeKey returns the store key of the given key.
+func (oc *OpCache[K, T]) storeKey(key K) string {
+	if oc.cfg.StoreKeyFunc != nil {
+		return oc.cfg.StoreKeyFunc(key)
+	}
+	return fmt.Sprint(key)
+}
+
+// storeError reports an error of a store operation of key.
+func (oc *OpCache[K, T]) storeError(key any, err error) {
+	if oc.cfg.OnStoreError != nil {
+		oc.cfg.OnStoreError(key, err)
+	}
+}
+
+// storeGet looks up the entry of key in the store.
+// ok tells if a valid entry is found, in which case opts holds the expirations (and tags) of the stored entry.
+func (oc *OpCache[K, T]) storeGet(ctx context.Context, key K) (result T, opts EntryOptions, ok bool) {
+	data, found, err := oc.cfg.Store.Get(ctx, oc.storeKey(key))
+	if err != nil {
+		oc.storeError(key, fmt.Errorf("failed to get from store: %w", err))
+		return
+	}
+	if !found {
+		return
+	}
+
+	var entry opCacheStoreEntry[T]
+	if err := oc.cfg.StoreCodec.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		oc.storeError(key, fmt.Errorf("failed to decode store entry: %w", err))
+		return
+	}
+
+	now := oc.cfg.Clock.Now()
+	if !now.Before(entry.ExpiresAt) {
+		// Not valid, it has to be reloaded.
+		return
+	}
+
+	expiration, graceExpiration := entry.ExpiresAt.Sub(now), entry.GraceExpiresAt.Sub(entry.ExpiresAt)
+	opts = EntryOptions{
+		Expiration:      &expiration,
+		GraceExpiration: &graceExpiration,
+		Tags:            entry.Tags,
+		fromStore:       true,
+	}
+	return entry.Result, opts, true
+}
+
+// storeSet writes the (non-error) result opr of key to the store.
+func (oc *OpCache[K, T]) storeSet(key K, opr *opResult[T]) {
+	var buf bytes.Buffer
+	entry := opCacheStoreEntry[T]{
+		Result:         opr.result,
+		ExpiresAt:      opr.expiresAt,
+		GraceExpiresAt: opr.graceExpiresAt,
+		Tags:           opr.tags,
+	}
+	if err := oc.cfg.StoreCodec.NewEncoder(&buf).Encode(entry); err != nil {
+		oc.storeError(key, fmt.Errorf("failed to encode store entry: %w", err))
+		return
+	}
+
+	expiration := opr.graceExpiresAt.Sub(oc.cfg.Clock.Now())
+	if expiration <= 0 {
+		return
+	}
+	if err := oc.cfg.Store.Set(context.Background(), oc.storeKey(key), buf.Bytes(), expiration); err != nil {
+		oc.storeError(key, fmt.Errorf("failed to set in store: %w", err))
+	}
+}
+
+// invalidate deletes the entries of the given (removed) keys from the store, and broadcasts their invalidation.
+func (oc *OpCache[K, T]) invalidate(keys []K) {
+	if len(keys) == 0 {
+		return
+	}
+
+	if oc.cfg.Store != nil {
+		storeKeys := make([]string, len(keys))
+		for i, key := range keys {
+			storeKeys[i] = oc.storeKey(key)
+		}
+		if err := oc.cfg.Store.Delete(context.Background(), storeKeys...); err != nil {
+			oc.storeError(keys, fmt.Errorf("failed to delete from store: %w", err))
+		}
+	}
+
+	if oc.cfg.OnInvalidate != nil {
+		anyKeys := make([]any, len(keys))
+		for i, key := range keys {
+			anyKeys[i] = key
+		}
+		oc.cfg.OnInvalidate(anyKeys)
+	}
+}
+
+// withStore returns a loadFunc that first looks up key in the store, and only calls execOp if not found.
+// If no store is configured, execOp is returned.
+func (oc *OpCache[K, T]) withStore(key K, execOp loadFunc[T]) loadFunc[T] {
+	if oc.cfg.Store == nil {
+		return execOp
+	}
+
+	return func(ctx context.Context) (T, EntryOptions, error) {
+		if result, opts, ok := oc.storeGet(ctx, key); ok {
+			return result, opts, nil
+		}
+		return execOp(ctx)
+	}
+}
+
+// execMultiOpWithStore looks up the keys designated by keyIndices in the store (if configured),
+// and calls execMultiOp() for keys not found. opts holds the entry options of the results
+// (may be nil if no store is configured).
+func (oc *OpCache[K, T]) execMultiOpWithStore(
+	ctx context.Context,
+	keys []K,
+	keyIndices []int,
+	execMultiOp multiLoadFunc[T],
+) (results []T, resultErrs []error, opts []EntryOptions) {
+
+	if oc.cfg.Store == nil {
+		results, opts, resultErrs = oc.execMultiOp(ctx, keys, keyIndices, execMultiOp)
+		return
+	}
+
+	results, resultErrs, opts = make([]T, len(keyIndices)), make([]error, len(keyIndices)), make([]EntryOptions, len(keyIndices))
+
+	var missing []int // Indices into keyIndices not found in the store
+	for i, keyIdx := range keyIndices {
+		var ok bool
+		if results[i], opts[i], ok = oc.storeGet(ctx, keys[keyIdx]); !ok {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	missingKeyIndices := make([]int, len(missing))
+	for j, i := range missing {
+		missingKeyIndices[j] = keyIndices[i]
+	}
+	missingResults, missingOpts, missingErrs := oc.execMultiOp(ctx, keys, missingKeyIndices, execMultiOp)
+	for j, i := range missing {
+		results[i], opts[i], resultErrs[i] = missingResults[j], entryOptionsAt(missingOpts, j), missingErrs[j]
+	}
+	return
+}
+
+// entryOptionsAt returns opts[i], or zero options if opts is nil.
+func entryOptionsAt(opts []EntryOptions, i int) EntryOptions {
+	if opts == nil {
+		return EntryOptions{}
+	}
+	return opts[i]
+}
+
+// MemoryStore is an in-memory [OpCacheStore]. It's mainly useful for testing,
+// and as a reference implementation.
+//
+// Expired values are deleted when they are accessed.
+type MemoryStore struct {
+	clock Clock
+
+	mu     sync.Mutex
+	values map[string]memoryStoreValue
+}
+
+// memoryStoreValue is a value stored in a MemoryStore.
+type memoryStoreValue struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore.
+// clock is used to tell the time when checking expiration, if nil, RealClock is used.
+func NewMemoryStore(clock Clock) *MemoryStore {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &MemoryStore{
+		clock:  clock,
+		values: map[string]memoryStoreValue{},
+	}
+}
+
+// Get implements [OpCacheStore.Get].
+func (ms *MemoryStore) Get(ctx context.Context, key string) (value []byte, found bool, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	v, found := ms.values[key]
+	if !found {
+		return nil, false, nil
+	}
+	if !ms.clock.Now().Before(v.expiresAt) {
+		delete(ms.values, key)
+		return nil, false, nil
+	}
+	return bytes.Clone(v.value), true, nil
+}
+
+// Set implements [OpCacheStore.Set].
+func (ms *MemoryStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.values[key] = memoryStoreValue{value: bytes.Clone(value), expiresAt: ms.clock.Now().Add(expiration)}
+	return nil
+}
+
+// Delete implements [OpCacheStore.Delete].
+func (ms *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, key := range keys {
+		delete(ms.values, key)
+	}
+	return nil
+}
+
+// FileStore is an [OpCacheStore] storing values in files of a directory.
+// Multiple processes on the same host may share a FileStore by using the same directory.
+//
+// Each value is stored in its own file (named after the hash of its key), prefixed with its expiration time.
+// Expired values are deleted when they are accessed.
+type FileStore struct {
+	dir   string
+	clock Clock
+}
+
+// NewFileStore creates a new FileStore using the given directory, which is created if it doesn't exist.
+// clock is used to tell the time when checking expiration, if nil, RealClock is used.
+func NewFileStore(dir string, clock Clock) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	if clock == nil {
+		clock = RealClock
+	}
+	return &FileStore{dir: dir, clock: clock}, nil
+}
+
+// fileName returns the name of the file storing the value of key.
+func (fs *FileStore) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get implements [OpCacheStore.Get].
+func (fs *FileStore) Get(ctx context.Context, key string) (value []byte, found bool, err error) {
+	name := fs.fileName(key)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(data) < 8 {
+		return nil, false, fmt.Errorf("invalid store file: %s", name)
+	}
+
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	if !fs.clock.Now().Before(expiresAt) {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	return data[8:], true, nil
+}
+
+// Set implements [OpCacheStore.Set].
+func (fs *FileStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(data, uint64(fs.clock.Now().Add(expiration).UnixNano()))
+	data = append(data, value...)
+
+	// Write to a temp file and rename it, so readers never see partially written files:
+	f, err := os.CreateTemp(fs.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), fs.fileName(key)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements [OpCacheStore.Delete].
+func (fs *FileStore) Delete(ctx context.Context, keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := os.Remove(fs.fileName(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,8 +1,14 @@
 package gox
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"hash/maphash"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,6 +16,9 @@ const DefaultEvictPeriodMinutes = 15
 
 // OpCacheConfig holds configuration options for an [OpCache].
 type OpCacheConfig struct {
+	// Name is an optional name of the cache, used to identify it e.g. on debug pages (see [OpCacheInspector]).
+	Name string
+
 	// Operation results are valid for this long after creation.
 	ResultExpiration time.Duration
 
@@ -28,12 +37,138 @@ type OpCacheConfig struct {
 	// (regardless of how many times it is accessed from the OpCache).
 	ErrorExpiration func(err error) (discard bool, expiration, graceExpiration *time.Duration)
 
+	// ExpirationJitterPercent is an optional percentage to randomly adjust result expirations by (in either direction),
+	// so entries created at the same time do not all expire at the same instant.
+	// For example, if 10 is given, a 1-minute expiration may become anything between 54 and 66 seconds.
+	ExpirationJitterPercent float64
+
+	// RefreshAhead enables proactive refreshing: if positive, entries are reloaded in the background
+	// this long before they expire (instead of being reloaded when accessed within their grace period).
+	// Entries are refreshed using the loader they were last loaded with (e.g. the execOp passed to [OpCache.Get]),
+	// or using the loader of the cache if set (see [OpCache.WithLoader], it must be set to refresh entries
+	// loaded by [OpCache.MultiGet] or cached by [OpCache.Set]).
+	//
+	// Refreshed results are cached just like background reloads (see KeepResultOnReloadError).
+	// Use [OpCache.Close] to stop refreshing.
+	RefreshAhead time.Duration
+
+	// RefreshConcurrency is the maximum number of refreshes running at once. If 0, 1 is used.
+	// Entries not refreshed due to this limit are refreshed later (if still due).
+	RefreshConcurrency int
+
+	// RefreshIdlePeriod tells to stop refreshing entries that have not been read for this long.
+	// Such entries expire (and are evicted) normally.
+	// If 0, ResultExpiration is used.
+	RefreshIdlePeriod time.Duration
+
+	// OnLoad is an optional hook called after each op execution (loading a key on a miss, or reloading it),
+	// with the duration of the execution and the error it returned (including panics turned into errors).
+	// If a multi-operation is executed (see [OpCache.MultiGet]), OnLoad is called for each of its keys
+	// with the duration of the whole execution.
+	OnLoad func(key any, d time.Duration, err error)
+
+	// OnHit is an optional hook called when a valid cached result is returned.
+	OnHit func(key any)
+
+	// OnGraceHit is an optional hook called when a grace-valid cached result is returned
+	// (which triggers a background reload).
+	OnGraceHit func(key any)
+
+	// OnEvict is an optional hook called when an entry is removed from the cache, with the reason of the removal.
+	// It's not called when an entry is replaced by a new result of the same key.
+	// OnEvict is called after the cache (shard) is unlocked, so it may use the cache.
+	OnEvict func(key any, reason EvictionReason)
+
+	// OnReloadError is an optional hook called when a background reload (see ResultGraceExpiration
+	// and RefreshAhead) returns an error (or panics), whether or not the error result is cached.
+	OnReloadError func(key any, err error)
+
+	// KeepResultOnReloadError tells to keep the grace-valid result if its background reload fails
+	// (returns a non-nil error or panics). The reload may be retried by subsequent lookups within the grace period.
+	//
+	// If false, the error result of a failed reload replaces the grace-valid result
+	// (unless it's discarded, see ErrorExpiration, in which case the grace-valid result is kept).
+	KeepResultOnReloadError bool
+
 	// AutoEvictPeriodMinutes tells how frequently should expired entries be checked and evicted from the cache.
-	// If 0, DefaultEvictPeriodMinutes will be used. Removal is currently not supported.
+	// If 0, DefaultEvictPeriodMinutes will be used. Use [OpCache.Close] to remove the cache from the evictor.
 	//
 	// If a negative value is given, the op cache is not added to the internal auto-evictor, and manual eviction
 	// should be taken care of with e.g. using the RunEvictor() function.
 	AutoEvictPeriodMinutes int
+
+	// Evictor is an optional evictor the cache is added to (unless AutoEvictPeriodMinutes is negative).
+	// If nil, the global evictor is used.
+	Evictor *Evictor
+
+	// MaxEntries is the maximum number of entries the cache may hold.
+	// If adding a new entry would exceed this limit, entries are evicted as chosen by EvictionPolicy.
+	// If 0, the number of entries is not limited (only invalid entries are evicted, see [OpCache.Evict]).
+	MaxEntries int
+
+	// MaxWeight is the maximum total weight of entries the cache may hold, as told by the weigher
+	// of the cache (see [OpCache.WithWeigher]). If no weigher is set, entries weigh nothing.
+	// If adding a new entry would exceed this limit, entries are evicted as chosen by EvictionPolicy.
+	// An entry heavier than the limit itself is not kept (it's evicted right away, without evicting others).
+	// If 0, the total weight is not limited.
+	//
+	// Unlike MaxEntries, MaxWeight is not distributed among shards, it limits the total weight of all shards
+	// (entries are evicted from the heaviest shard first).
+	MaxWeight int64
+
+	// EvictionPolicy tells which entries to evict when MaxEntries or MaxWeight is reached.
+	// Defaults to EvictionLRU. Only used if MaxEntries > 0 or MaxWeight > 0.
+	EvictionPolicy EvictionPolicy
+
+	// Metrics is an optional hook to receive counter changes as they happen.
+	// Counters can also be queried using [OpCache.Stats].
+	Metrics OpCacheMetrics
+
+	// Clock is used to tell the time when calculating and checking expiration.
+	// Defaults to RealClock. Tip: use a [FakeClock] to test expiration deterministically.
+	//
+	// Note: the global evictor measures eviction periods using its own clock, see [SetGlobalEvictorClock].
+	Clock Clock
+
+	// ShardCount is the number of shards to split the cache into.
+	// Each shard has its own locks, which reduces lock contention under highly concurrent use.
+	// Keys are distributed among shards by their hash. If 0 or 1, the cache is not sharded.
+	//
+	// If MaxEntries is set, it is distributed evenly among shards (each shard may hold MaxEntries/ShardCount entries,
+	// the remainder is spread among the first shards so the limits of shards add up to exactly MaxEntries).
+	// Since each shard must hold at least 1 entry, ShardCount is reduced to MaxEntries if it's greater.
+	ShardCount int
+
+	// KeyHasher is an optional function to hash keys, only used if ShardCount > 1.
+	// If not provided, keys are hashed using [maphash.Comparable], which supports all key types
+	// (including [Struct2] ... [Struct6]).
+	KeyHasher func(key any) uint64
+
+	// Store is an optional second-tier store, typically a remote store shared by multiple service instances.
+	// If provided, the store is checked before executing an operation, and (non-error) results are written
+	// to the store after. Entries loaded from the store keep the expiration they were stored with.
+	//
+	// Results (along with their expirations and tags) are encoded using StoreCodec,
+	// so the result type must be encodable by it.
+	Store OpCacheStore
+
+	// StoreCodec is the codec used to encode entries written to Store. Defaults to [GobCodec].
+	StoreCodec Codec
+
+	// StoreKeyFunc is an optional function to produce the store key of a key.
+	// If not provided, fmt.Sprint() is used.
+	// Tip: if multiple caches share the same store, use this to prefix keys with the cache's name.
+	StoreKeyFunc func(key any) string
+
+	// OnStoreError is an optional function called when a store operation fails.
+	// Store errors are not fatal: the cache proceeds as if the store didn't have the entry.
+	// key is the key (or the slice of keys) of the failed operation.
+	OnStoreError func(key any, err error)
+
+	// OnInvalidate is an optional hook called with the keys removed by [OpCache.Remove], [OpCache.RemoveByTag]
+	// and [OpCache.RemoveFunc]. It can be used to broadcast invalidations to other service instances
+	// (which should call [OpCache.RemoveLocal] to apply them).
+	OnInvalidate func(keys []any)
 }
 
 // OpCache implements a general value cache. It can be used to cache results of arbitrary operations.
@@ -61,92 +196,258 @@ type OpCacheConfig struct {
 type OpCache[K comparable, T any] struct {
 	cfg OpCacheConfig
 
-	keyResultsMu sync.RWMutex
-	keyResults   map[K]*opResult[T]
+	shards []*opCacheShard[K, T]
+	seed   maphash.Seed // Used to hash keys if there are multiple shards and no KeyHasher is provided
+
+	inFlight atomic.Int64 // Number of keys being loaded
+	weight   atomic.Int64 // Total weight of entries of all shards, see OpCacheConfig.MaxWeight
+
+	weigher func(key K, result T) int64 // Tells the weight of entries, see WithWeigher
+
+	loader atomic.Pointer[func(ctx context.Context, key K) (T, error)] // Loads results to refresh entries with, see WithLoader
+
+	evictor *Evictor // The evictor the cache is added to, nil if none
 
-	// Using a simple Mutex (instead of RWMutex) for this one, as the majority of cases will not be a concurrent Get() with same key.
-	// Code is simpler and faster for the majority of cases (for the very rare it's still correct but may be slower a bit).
-	keyFirstExecOpOncesMu sync.Mutex
-	keyFirstExecOpOnces   map[K]*sync.Once
+	refreshSem  chan struct{}      // Semaphore limiting concurrent refreshes, nil if refreshing is disabled
+	stopRefresh context.CancelFunc // Stops the refresher goroutine and cancels ongoing refreshes
 }
 
 // NewOpCache creates a new OpCache.
 func NewOpCache[K comparable, T any](cfg OpCacheConfig) *OpCache[K, T] {
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock
+	}
+	if cfg.StoreCodec == nil {
+		cfg.StoreCodec = GobCodec
+	}
+
+	shardCount := ForceMin(cfg.ShardCount, 1)
+	if cfg.MaxEntries > 0 {
+		shardCount = ForceMax(shardCount, cfg.MaxEntries) // Each shard must hold at least 1 entry
+	}
+
 	opCache := &OpCache[K, T]{
-		cfg:                 cfg,
-		keyResults:          map[K]*opResult[T]{},
-		keyFirstExecOpOnces: map[K]*sync.Once{},
+		cfg:    cfg,
+		shards: make([]*opCacheShard[K, T], shardCount),
+		seed:   maphash.MakeSeed(),
+	}
+
+	// MaxEntries is distributed evenly among shards (MaxWeight is enforced across shards, see enforceMaxWeight):
+	for i := range opCache.shards {
+		shardMaxEntries := cfg.MaxEntries / shardCount
+		if i < cfg.MaxEntries%shardCount {
+			shardMaxEntries++ // Spread the remainder so shard limits add up to MaxEntries
+		}
+		opCache.shards[i] = newOpCacheShard[K, T](cfg, shardMaxEntries, &opCache.weight)
 	}
 
 	if cfg.AutoEvictPeriodMinutes >= 0 {
-		epMins := cfg.AutoEvictPeriodMinutes
-		if epMins == 0 {
-			epMins = DefaultEvictPeriodMinutes
+		opCache.evictor = cfg.Evictor
+		if opCache.evictor == nil {
+			opCache.evictor = globalEvictor
 		}
-		addToGlobalEvictor(opCache, epMins)
+		opCache.evictor.Add(opCache, cfg.AutoEvictPeriodMinutes)
+	}
+
+	if cfg.RefreshAhead > 0 {
+		opCache.startRefresher()
 	}
 
 	return opCache
 }
 
-func (oc *OpCache[K, T]) getCachedOpResult(key K) *opResult[T] {
-	oc.keyResultsMu.RLock()
-	defer oc.keyResultsMu.RUnlock()
+// Close removes the cache from its evictor, so it can be garbage collected once it's no longer referenced.
+// Refreshing (see [OpCacheConfig.RefreshAhead]) is also stopped, and cached entries are removed.
+//
+// The cache remains usable after Close, but invalid entries are no longer evicted automatically.
+func (oc *OpCache[K, T]) Close() {
+	if oc.evictor != nil {
+		oc.evictor.Remove(oc)
+	}
+	if oc.stopRefresh != nil {
+		oc.stopRefresh()
+	}
+	oc.Clear()
+}
 
-	return oc.keyResults[key]
+func (oc *OpCache[K, T]) getCachedOpResult(key K) *opResult[T] {
+	return oc.shard(key).get(key)
 }
 
 func (oc *OpCache[K, T]) setCachedOpResult(key K, opResults *opResult[T]) {
-	oc.keyResultsMu.Lock()
-	oc.keyResults[key] = opResults
-	oc.keyResultsMu.Unlock()
+	oc.shard(key).set(key, opResults)
+	if oc.cfg.MaxWeight > 0 {
+		oc.enforceMaxWeight()
+	}
+}
+
+// enforceMaxWeight evicts entries while the total weight of entries exceeds MaxWeight,
+// always from the heaviest shard (as chosen by its eviction policy).
+func (oc *OpCache[K, T]) enforceMaxWeight() {
+	for oc.weight.Load() > oc.cfg.MaxWeight {
+		heaviest, heaviestWeight := oc.shards[0], oc.shards[0].totalWeight()
+		for _, sh := range oc.shards[1:] {
+			if w := sh.totalWeight(); w > heaviestWeight {
+				heaviest, heaviestWeight = sh, w
+			}
+		}
+		if !heaviest.evictOne() {
+			return
+		}
+	}
 }
 
 // Evict checks all cached entries, and removes invalid ones.
 func (oc *OpCache[K, T]) Evict() {
-	oc.keyResultsMu.Lock()
-	defer oc.keyResultsMu.Unlock()
-
-	for key, opResult := range oc.keyResults {
-		if !opResult.graceValid() { // Delete if not even grace-valid
-			delete(oc.keyResults, key)
-		}
+	now := oc.cfg.Clock.Now()
+	for _, sh := range oc.shards {
+		sh.evict(now)
 	}
 }
 
 // Clear removes all cached entries.
 func (oc *OpCache[K, T]) Clear() {
-	oc.keyResultsMu.Lock()
-	defer oc.keyResultsMu.Unlock()
-
-	for key := range oc.keyResults {
-		delete(oc.keyResults, key)
+	for _, sh := range oc.shards {
+		sh.clear()
 	}
 }
 
 // Remove removes all entries of the listed keys.
+//
+// Entries are also deleted from the store (if configured, see [OpCacheConfig.Store]),
+// and their invalidation is broadcast (see [OpCacheConfig.OnInvalidate]).
 func (oc *OpCache[K, T]) Remove(keys ...K) {
-	oc.keyResultsMu.Lock()
-	defer oc.keyResultsMu.Unlock()
+	oc.RemoveLocal(keys...)
+	oc.invalidate(keys)
+}
+
+// RemoveLocal removes all entries of the listed keys from this cache only.
+// Unlike [OpCache.Remove], entries are not deleted from the store and their invalidation is not broadcast.
+// This is how invalidations received from other service instances should be applied.
+func (oc *OpCache[K, T]) RemoveLocal(keys ...K) {
+	if len(oc.shards) == 1 {
+		oc.shards[0].remove(keys...)
+		return
+	}
 
 	for _, key := range keys {
-		delete(oc.keyResults, key)
+		oc.shard(key).remove(key)
+	}
+}
+
+// RemoveByTag removes all entries having any of the listed tags (see [EntryOptions.Tags]).
+// Returns the number of removed entries.
+//
+// Removed entries are also deleted from the store, and their invalidation is broadcast (see [OpCache.Remove]).
+// Note that only the tags of entries present in this cache are known.
+func (oc *OpCache[K, T]) RemoveByTag(tags ...string) (n int) {
+	var keys []K
+	for _, sh := range oc.shards {
+		keys = append(keys, sh.removeByTags(tags...)...)
 	}
+	oc.invalidate(keys)
+	return len(keys)
 }
 
-// execOpAndCacheResult executes execOp(), caches the result according to the configuration, and returns it
+// RemoveFunc removes all entries for which f returns true.
+// Returns the number of removed entries.
+//
+// Removed entries are also deleted from the store, and their invalidation is broadcast (see [OpCache.Remove]).
+//
+// f is called while the cache (shard) is locked, so it must not call methods of the cache.
+func (oc *OpCache[K, T]) RemoveFunc(f func(key K, entry OpCacheEntry[T]) bool) (n int) {
+	var keys []K
+	for _, sh := range oc.shards {
+		keys = append(keys, sh.removeFunc(f)...)
+	}
+	oc.invalidate(keys)
+	return len(keys)
+}
+
+// execOpAndCacheResult executes execOp(), caches the result according to the configuration, and returns it.
+// cached tells if the result was cached.
+//
+// loader is the loader to remember for refreshing the entry (see [OpCacheConfig.RefreshAhead]).
 func (oc *OpCache[K, T]) execOpAndCacheResult(
+	ctx context.Context,
 	key K,
-	execOp func() (result T, err error),
-) (result T, resultErr error) {
+	execOp, loader loadFunc[T],
+) (result T, resultErr error, cached bool) {
+
+	result, opts, resultErr := oc.execOp(ctx, key, execOp)
+
+	if ctx.Err() != nil {
+		// Op execution was cancelled (nobody is waiting for it), don't cache its result.
+		return
+	}
+
+	opts.read = true // The op was executed because the key was read
+	cached = oc.cache(key, result, resultErr, opts, loader)
+
+	return
+}
 
-	result, resultErr = execOp()
+// loadFunc is the general form of functions executing an operation for a single key.
+type loadFunc[T any] func(ctx context.Context) (result T, opts EntryOptions, err error)
 
-	oc.Set(key, result, resultErr)
+// execOp executes execOp() for key but protects against panics: a panic is turned into an error result like [Protect] does.
+func (oc *OpCache[K, T]) execOp(
+	ctx context.Context,
+	key K,
+	execOp loadFunc[T],
+) (result T, opts EntryOptions, resultErr error) {
 
+	oc.inFlight.Add(1)
+	defer oc.inFlight.Add(-1)
+
+	start := time.Now()
+	if err := Protect(func() { result, opts, resultErr = execOp(ctx) }); err != nil {
+		var zero T
+		result, opts, resultErr = zero, EntryOptions{}, err
+	}
+
+	if oc.cfg.OnLoad != nil {
+		oc.cfg.OnLoad(key, time.Since(start), resultErr)
+	}
 	return
 }
 
+// reload executes execOp() to refresh the grace-valid result opr of key (in the background), and caches the result.
+//
+// If the result is not cached (it's a discarded error, or [OpCacheConfig.KeepResultOnReloadError] is set),
+// the grace-valid result is kept and its reloading flag is reset, so a subsequent lookup may retry reloading it.
+//
+// loader is the loader to remember for refreshing the entry (see [OpCacheConfig.RefreshAhead]).
+//
+// If ctx is cancelled (e.g. the cache is closed), the result is not cached nor reported as a reload error.
+func (oc *OpCache[K, T]) reload(
+	ctx context.Context,
+	key K,
+	opr *opResult[T],
+	execOp, loader loadFunc[T],
+) {
+	result, opts, resultErr := oc.execOp(ctx, key, execOp)
+	if ctx.Err() != nil {
+		// Reload was cancelled, don't cache its result.
+		opr.endReload()
+		return
+	}
+	if !oc.setReloaded(key, result, resultErr, opts, loader) {
+		opr.endReload()
+	}
+}
+
+// setReloaded caches the result of a reload, and tells if it was cached.
+func (oc *OpCache[K, T]) setReloaded(key K, result T, resultErr error, opts EntryOptions, loader loadFunc[T]) (cached bool) {
+	if resultErr != nil && oc.cfg.OnReloadError != nil {
+		oc.cfg.OnReloadError(key, resultErr)
+	}
+	if resultErr != nil && oc.cfg.KeepResultOnReloadError {
+		return false
+	}
+	return oc.cache(key, result, resultErr, opts, loader)
+}
+
 // Set caches the given result with expiration defined by the configuration.
 //
 // Normally this doesn't need to be called, [Get] should be used which handles lookups, op execution and caching.
@@ -155,13 +456,50 @@ func (oc *OpCache[K, T]) execOpAndCacheResult(
 // Note that result may be discarded if resultErr is not nil and [OpCacheConfig.ErrorExpiration] was provided
 // which instructs to discard such result.
 func (oc *OpCache[K, T]) Set(key K, result T, resultErr error) {
+	oc.set(key, result, resultErr, EntryOptions{})
+}
+
+// EntryOptions holds options of a single cache entry, overriding the configuration of the [OpCache].
+type EntryOptions struct {
+	// Expiration overrides the result expiration if provided (non-nil).
+	// Useful e.g. if the operation's result comes with its own expiration (like a Cache-Control max-age).
+	Expiration *time.Duration
+
+	// GraceExpiration overrides the grace expiration if provided (non-nil).
+	GraceExpiration *time.Duration
+
+	// Tags to attach to the entry. Tags can be used to remove all entries having a tag at once,
+	// see [OpCache.RemoveByTag]. Typically used to tag entries with the entities their results are derived from.
+	Tags []string
+
+	fromStore bool // Tells if the entry was loaded from the store (and so it must not be written back)
+	read      bool // Tells if the entry was loaded because it was read (and not e.g. refreshed)
+}
+
+// SetWithOptions is like [OpCache.Set], but entry options may override the cache configuration.
+//
+// Expiration and grace expiration given in opts take precedence over [OpCacheConfig.ErrorExpiration],
+// but an error result is still discarded if ErrorExpiration instructs so.
+func (oc *OpCache[K, T]) SetWithOptions(key K, result T, resultErr error, opts EntryOptions) {
+	oc.set(key, result, resultErr, opts)
+}
+
+// set implements Set, and tells if the result was cached.
+func (oc *OpCache[K, T]) set(key K, result T, resultErr error, opts EntryOptions) (cached bool) {
+	return oc.cache(key, result, resultErr, opts, nil)
+}
+
+// cache caches the given result, and tells if it was cached.
+// loader is the loader to remember for refreshing the entry (see [OpCacheConfig.RefreshAhead]), may be nil.
+func (oc *OpCache[K, T]) cache(key K, result T, resultErr error, opts EntryOptions, loader loadFunc[T]) (cached bool) {
 	expiration, graceExpiration := oc.cfg.ResultExpiration, oc.cfg.ResultGraceExpiration
 
 	if resultErr != nil && oc.cfg.ErrorExpiration != nil {
 		discard, exp, graceExp := oc.cfg.ErrorExpiration(resultErr)
 		if discard {
 			// This error result is not to be cached at all, just return:
-			return
+			oc.shard(key).inc(counterDiscardedErrors, 1)
+			return false
 		}
 		if exp != nil {
 			expiration = *exp
@@ -171,7 +509,54 @@ func (oc *OpCache[K, T]) Set(key K, result T, resultErr error) {
 		}
 	}
 
-	oc.setCachedOpResult(key, newOpResult(result, resultErr, expiration, graceExpiration))
+	if opts.Expiration != nil {
+		expiration = *opts.Expiration
+	}
+	if opts.GraceExpiration != nil {
+		graceExpiration = *opts.GraceExpiration
+	}
+
+	if p := oc.cfg.ExpirationJitterPercent; p > 0 && !opts.fromStore {
+		// Randomly adjust expiration by up to p percent in either direction:
+		expiration += time.Duration(float64(expiration) * p / 100 * (2*rand.Float64() - 1))
+	}
+
+	now := oc.cfg.Clock.Now()
+	opr := newOpResult(now, result, resultErr, expiration, graceExpiration)
+	opr.tags = slices.Clone(opts.Tags)
+	opr.weight = oc.weigh(key, result)
+	if oc.refreshSem != nil {
+		opr.loader = loader
+		if opts.read {
+			opr.lastAccess.Store(now.UnixNano())
+		}
+	}
+	oc.setCachedOpResult(key, opr)
+
+	if oc.cfg.Store != nil && resultErr == nil && !opts.fromStore {
+		oc.storeSet(key, opr)
+	}
+	return true
+}
+
+// WithWeigher sets the function to tell the weight of an entry (e.g. its approximate size in bytes), and returns oc.
+// The weigher is called once for each result when it's cached (also for error results).
+// Only used if [OpCacheConfig.MaxWeight] > 0.
+//
+// WithWeigher must be called before the cache is used, typically right after creating it:
+//
+//	opc := NewOpCache[int, []byte](cfg).WithWeigher(func(key int, result []byte) int64 { return int64(len(result)) })
+func (oc *OpCache[K, T]) WithWeigher(weigher func(key K, result T) int64) *OpCache[K, T] {
+	oc.weigher = weigher
+	return oc
+}
+
+// weigh returns the weight of an entry, see [OpCache.WithWeigher]. Returns 0 if the weight is not limited.
+func (oc *OpCache[K, T]) weigh(key K, result T) int64 {
+	if oc.cfg.MaxWeight > 0 && oc.weigher != nil {
+		return oc.weigher(key, result)
+	}
+	return 0
 }
 
 var ErrExecOpFailedAndErrDiscarded = errors.New("exec op failed and error discarded")
@@ -194,86 +579,218 @@ var ErrExecOpFailedAndErrDiscarded = errors.New("exec op failed and error discar
 // Such concurrent Get calls will also wait for the ongoing execOp()'s return.
 // If the ongoing execOp() returns a discardable error (see [OpCacheConfig.ErrorExpiration]), it is
 // returned by the Get() call that ended up calling execOp(), and other concurrent Get calls will return
-// ErrExecOpFailedAndErrDiscarded. (If the error is cachable, all concurrent Get calls will return that.)
+// an error wrapping both ErrExecOpFailedAndErrDiscarded and the discarded error.
+// (If the error is cachable, all concurrent Get calls will return that.)
+//
+// If execOp() panics, the panic is recovered and turned into an error result like [Protect] does,
+// both when Get() waits for execOp() and when execOp() is called in the background.
+// If a background reload fails, see [OpCacheConfig.KeepResultOnReloadError].
 func (oc *OpCache[K, T]) Get(
 	key K,
 	execOp func() (result T, err error),
 ) (result T, resultErr error) {
 
-	cachedResult := oc.getCachedOpResult(key)
+	// Fast path: don't wrap execOp (which allocates) if the cached result is valid.
+	sh := oc.shard(key)
+	now := oc.cfg.Clock.Now()
+	if cachedResult := sh.get(key); cachedResult.valid(now) {
+		sh.hit(key)
+		oc.accessed(cachedResult, now)
+		return cachedResult.result, cachedResult.resultErr
+	}
 
-	if cachedResult.valid() {
+	return oc.GetWithOptions(context.Background(), key, func(context.Context) (T, EntryOptions, error) {
+		result, err := execOp()
+		return result, EntryOptions{}, err
+	})
+}
+
+// GetCtx is like [OpCache.Get], but it is context-aware.
+//
+// execOp() receives a context which is cancelled when no caller is waiting for its result anymore.
+// If ctx is cancelled while waiting for execOp() to return, GetCtx returns ctx.Err() immediately.
+// Since concurrent Get and GetCtx calls (for the same key) share a single execOp() execution,
+// the context passed to execOp() is only cancelled when all waiting callers have gone away.
+// Results of a cancelled execOp() are not cached.
+//
+// Panics of execOp() are turned into error results (see [OpCache.Get]), but if a hook of the cache
+// (e.g. [OpCacheConfig.ErrorExpiration] or [OpCacheConfig.OnLoad]) panics, the panic is propagated to the caller
+// that started the op execution, even if it's executed in a new goroutine.
+// Other callers waiting for the same execution get an error wrapping both ErrExecOpFailedAndErrDiscarded and a *[PanicError].
+//
+// Background reloads (within the grace period) are not tied to the cancellation of ctx,
+// but values of ctx are passed on to execOp().
+func (oc *OpCache[K, T]) GetCtx(
+	ctx context.Context,
+	key K,
+	execOp func(ctx context.Context) (result T, err error),
+) (result T, resultErr error) {
+
+	return oc.GetWithOptions(ctx, key, func(ctx context.Context) (T, EntryOptions, error) {
+		result, err := execOp(ctx)
+		return result, EntryOptions{}, err
+	})
+}
+
+// GetWithOptions is like [OpCache.GetCtx], but execOp() may also return entry options for its result,
+// which may override the cache configuration (e.g. the expiration of the result).
+// See [OpCache.SetWithOptions] for details.
+func (oc *OpCache[K, T]) GetWithOptions(
+	ctx context.Context,
+	key K,
+	execOp func(ctx context.Context) (result T, opts EntryOptions, err error),
+) (result T, resultErr error) {
+
+	sh := oc.shard(key)
+	cachedResult := sh.get(key)
+	now := oc.cfg.Clock.Now()
+
+	if cachedResult.valid(now) {
+		sh.hit(key)
+		oc.accessed(cachedResult, now)
 		return cachedResult.result, cachedResult.resultErr
 	}
 
-	if !cachedResult.graceValid() {
+	loader := loadFunc[T](execOp)
+	execOp = oc.withStore(key, execOp)
+
+	if !cachedResult.graceValid(now) {
+		sh.inc(counterMisses, 1)
 		// Not valid and not even within grace period: query, cache and return.
-		// But make sure execOp is only called once, use a sync.Once for that:
-		oc.keyFirstExecOpOncesMu.Lock()
-		execOpOnce := oc.keyFirstExecOpOnces[key]
-		if execOpOnce == nil {
-			execOpOnce = &sync.Once{}
-			oc.keyFirstExecOpOnces[key] = execOpOnce
-		}
-		oc.keyFirstExecOpOncesMu.Unlock()
-
-		// If the same key is queried concurrently, regardless of who creates sync.Once for it (above),
-		// no guarantee who will end up calling execOp, so track if we're the lucky ones:
-		lucky := false
-		execOpOnce.Do(func() {
-			lucky = true
-			// If we were the "lucky" ones, remove the execOpOnce in the end.
-			// Call it deferred to make sure execOpOnce is removed even if execOpAndCache (execOp panics).
-			// (result is already cached by execOpAndCache() so from this point on the sync.Once associated with key is no longer needed).
-			defer func() {
-				oc.keyFirstExecOpOncesMu.Lock()
-				delete(oc.keyFirstExecOpOnces, key)
-				oc.keyFirstExecOpOncesMu.Unlock()
-			}()
-			result, resultErr = oc.execOpAndCacheResult(key, execOp)
-		})
-		if lucky {
-			return // We have the results stored in the return parameters
-		}
-		// "Not lucky", someone else called execOpAndCache(), access the result from cache:
-		cachedResult := oc.getCachedOpResult(key)
-		if cachedResult.valid() {
-			return cachedResult.result, cachedResult.resultErr
+		// But make sure execOp is only called once, join an ongoing call if there is one:
+		call, started := sh.joinOrStartCall(ctx, key)
+		if started {
+			if ctx.Done() == nil {
+				// ctx is never cancelled, no need for a new goroutine: execute op in ours.
+				oc.execCall(sh, key, call, execOp, loader)
+			} else {
+				go oc.execCall(sh, key, call, execOp, loader)
+			}
 		}
-		// If not valid, that means execOp returned a non-cachable error:
-		resultErr = ErrExecOpFailedAndErrDiscarded
-		return
+		return waitCall(ctx, sh, key, call, started)
 	}
 
 	// Cached result is within grace period, we can use it:
 	result, resultErr = cachedResult.result, cachedResult.resultErr
+	sh.graceHit(key)
+	oc.accessed(cachedResult, now)
 
-	// But need to reload, in the background.
-	// First use read-lock to check if someone's already doing it:
+	// But need to reload, in the background (if someone's not already doing it):
+	if cachedResult.claimReload() {
+		sh.inc(counterReloads, 1)
+		// reload in new goroutine.
+		// Note: we're not using the return values, we're returning the cached (grace-valid) values.
+		go oc.reload(context.WithoutCancel(ctx), key, cachedResult, execOp, loader)
+	}
 
-	cachedResult.reloadMu.RLock()
-	reloading := cachedResult.reloading
-	cachedResult.reloadMu.RUnlock()
-	if reloading {
-		// Already reloading, nothing to do
-		return
+	return
+}
+
+// opCall represents an ongoing op execution of a key that callers wait for.
+type opCall[T any] struct {
+	done chan struct{} // Closed when the op execution has finished
+
+	// Fields set before done is closed:
+	result    T
+	resultErr error
+	cached    bool // Tells if the result was cached
+	panicked  bool // Tells if the op execution (including caching its result) panicked
+	panicVal  any  // The value the op execution panicked with
+
+	ctx    context.Context // Context of the op execution
+	cancel context.CancelFunc
+
+	waiters int // Number of waiting callers, guarded by opCacheShard.keyCallsMu
+}
+
+// joinOrStartCall returns the ongoing call of the given key, or registers a new one.
+// started tells if a new call was registered, in which case the caller is responsible to execute it.
+// In both cases the caller is registered as a waiter.
+func (sh *opCacheShard[K, T]) joinOrStartCall(ctx context.Context, key K) (call *opCall[T], started bool) {
+	sh.keyCallsMu.Lock()
+	defer sh.keyCallsMu.Unlock()
+
+	call = sh.keyCalls[key]
+	if call == nil {
+		call = &opCall[T]{done: make(chan struct{})}
+		// The op execution must not be cancelled when its starter goes away (others might be waiting for it),
+		// it's only cancelled when all waiters have gone away.
+		call.ctx, call.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		sh.keyCalls[key] = call
+		started = true
 	}
+	call.waiters++
 
-	// Try to take ownership of reloading, needs write-lock:
-	cachedResult.reloadMu.Lock()
-	if cachedResult.reloading {
-		// Someone else got the write-lock first, he'll take care of the reload
-		cachedResult.reloadMu.Unlock()
-		return
+	return
+}
+
+// execCall executes execOp for the given call, caches and stores the result, and signals its completion.
+// loader is the loader to remember for refreshing the entry (see [OpCacheConfig.RefreshAhead]).
+func (oc *OpCache[K, T]) execCall(sh *opCacheShard[K, T], key K, call *opCall[T], execOp, loader loadFunc[T]) {
+	// Make sure the call is completed even if caching the result panics (execOp itself is protected, see execOp).
+	defer sh.completeCall(key, call)
+	// We may be executed in a new goroutine: recover the panic so it can be re-panicked in the starter's goroutine.
+	defer func() {
+		if r := recover(); r != nil {
+			call.panicked, call.panicVal = true, r
+		}
+	}()
+
+	call.result, call.resultErr, call.cached = oc.execOpAndCacheResult(call.ctx, key, execOp, loader)
+}
+
+// completeCall unregisters the given call and signals its completion.
+// Result fields of the call must be set before this is called.
+func (sh *opCacheShard[K, T]) completeCall(key K, call *opCall[T]) {
+	// Note: the result is already cached at this point, so new callers will not need the call anymore.
+	sh.keyCallsMu.Lock()
+	if sh.keyCalls[key] == call {
+		delete(sh.keyCalls, key)
 	}
-	cachedResult.reloading = true // We'll be the one to do it
-	cachedResult.reloadMu.Unlock()
+	sh.keyCallsMu.Unlock()
 
-	// reload in new goroutine.
-	// Note: we're not using the return values, we're returning the cached (grace-valid) values.
-	go oc.execOpAndCacheResult(key, execOp)
+	call.cancel() // Release resources associated with call.ctx
+	close(call.done)
+}
 
-	return
+// waitCall waits for the given call to finish, and returns its result.
+// If ctx is cancelled before that, the caller is unregistered as a waiter and ctx.Err() is returned.
+// starter tells if the caller started the call.
+func waitCall[K comparable, T any](ctx context.Context, sh *opCacheShard[K, T], key K, call *opCall[T], starter bool) (result T, resultErr error) {
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		sh.leaveCall(key, call)
+		return result, ctx.Err()
+	}
+
+	if call.panicked {
+		if starter {
+			panic(call.panicVal)
+		}
+		return result, fmt.Errorf("%w: %w", ErrExecOpFailedAndErrDiscarded, &PanicError{Value: call.panicVal})
+	}
+	if !call.cached && !starter {
+		// Op execution returned a non-cachable error which is only returned to the starter:
+		return result, fmt.Errorf("%w: %w", ErrExecOpFailedAndErrDiscarded, call.resultErr)
+	}
+	return call.result, call.resultErr
+}
+
+// leaveCall unregisters a waiter of the given call.
+// If no waiters remain, the op execution is cancelled and the call is unregistered
+// so subsequent callers will start a new one.
+func (sh *opCacheShard[K, T]) leaveCall(key K, call *opCall[T]) {
+	sh.keyCallsMu.Lock()
+	defer sh.keyCallsMu.Unlock()
+
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		if sh.keyCalls[key] == call {
+			delete(sh.keyCalls, key)
+		}
+	}
 }
 
 // MultiGet gets the results of a multi-operation.
@@ -281,6 +798,7 @@ func (oc *OpCache[K, T]) Get(
 // more efficiently than calling the operation for each input separately.
 //
 // results and resultErrs will be slices with identical size and elements matching to that of keys.
+// keys may contain duplicates: a key is passed to execMultiOp() only once, and all its occurrences get the same result.
 //
 // Each result is taken from the cache if present and valid, or we're within its grace period.
 // If there are entries that are either not cached or we're past their grace period,
@@ -292,6 +810,11 @@ func (oc *OpCache[K, T]) Get(
 // to refresh each such entry even if [OpCache.Get] or MultiGet() is called multiple times with the same key(s)
 // before the cache can be refreshed.
 //
+// Care is also taken to not produce keys that are already being produced by concurrent Get() or MultiGet() calls:
+// such keys are not passed to execMultiOp(), instead MultiGet() waits for the ongoing op executions' results
+// (just like concurrent Get() calls do). Likewise, concurrent calls needing keys passed to execMultiOp()
+// will wait for its results.
+//
 // execMultiOp must return results and errs slices with identical size to that of its keyIndices argument,
 // and elements matching to keys designated by keyIndices! Failure to do so is undefined behavior,
 // may even result in runtime panic!
@@ -302,89 +825,249 @@ func (oc *OpCache[K, T]) MultiGet(
 	execMultiOp func(keyIndices []int) (results []T, errs []error),
 ) (results []T, resultErrs []error) {
 
+	return oc.MultiGetCtx(
+		context.Background(),
+		keys,
+		func(_ context.Context, keyIndices []int) ([]T, []error) { return execMultiOp(keyIndices) },
+	)
+}
+
+// MultiGetCtx is like [OpCache.MultiGet], but it is context-aware.
+//
+// If ctx is cancelled while waiting for results to be produced, MultiGetCtx returns immediately,
+// and ctx.Err() is returned for keys whose results were not yet produced.
+// execMultiOp() receives a context which is cancelled when no caller is waiting for any of its keys anymore.
+// Results of a cancelled execMultiOp() are not cached.
+//
+// Background reloads (within the grace period) are not tied to the cancellation of ctx,
+// but values of ctx are passed on to execMultiOp().
+func (oc *OpCache[K, T]) MultiGetCtx(
+	ctx context.Context,
+	keys []K,
+	execMultiOp func(ctx context.Context, keyIndices []int) (results []T, errs []error),
+) (results []T, resultErrs []error) {
+
+	return oc.MultiGetWithOptions(ctx, keys, func(ctx context.Context, keyIndices []int) ([]T, []EntryOptions, []error) {
+		results, errs := execMultiOp(ctx, keyIndices)
+		return results, nil, errs
+	})
+}
+
+// MultiGetWithOptions is like [OpCache.MultiGetCtx], but execMultiOp() may also return entry options for its results,
+// which may override the cache configuration (e.g. the expiration of results, or tags to attach to them).
+// See [OpCache.SetWithOptions] for details.
+//
+// opts returned by execMultiOp() may be nil (in which case no entry options are applied),
+// else it must have identical size to that of its keyIndices argument, and elements matching to keys designated by keyIndices.
+func (oc *OpCache[K, T]) MultiGetWithOptions(
+	ctx context.Context,
+	keys []K,
+	execMultiOp func(ctx context.Context, keyIndices []int) (results []T, opts []EntryOptions, errs []error),
+) (results []T, resultErrs []error) {
+
 	results = make([]T, len(keys))
 	resultErrs = make([]error, len(keys))
-	cachedResults := make([]*opResult[T], len(keys))
 
 	var (
 		invalidKeyIndices    []int // key indices that we must produce and wait for
 		graceValidKeyIndices []int // key indices that we may use but must refresh in the background
+		graceValidResults    []*opResult[T]
 	)
 
+	now := oc.cfg.Clock.Now()
 	for keyIdx, key := range keys {
-		cachedResult := oc.getCachedOpResult(key)
+		sh := oc.shard(key)
+		cachedResult := sh.get(key)
 
 		switch {
-		case cachedResult.valid():
+		case cachedResult.valid(now):
 			results[keyIdx], resultErrs[keyIdx] = cachedResult.result, cachedResult.resultErr
-		case cachedResult.graceValid():
+			sh.hit(key)
+			oc.accessed(cachedResult, now)
+		case cachedResult.graceValid(now):
 			// Cached result is within grace period, we can use it:
 			results[keyIdx], resultErrs[keyIdx] = cachedResult.result, cachedResult.resultErr
-			graceValidKeyIndices = append(graceValidKeyIndices, keyIdx)
-			cachedResults[keyIdx] = cachedResult
+			sh.graceHit(key)
+			oc.accessed(cachedResult, now)
+			// But need to reload, in the background (if someone's not already doing it):
+			if cachedResult.claimReload() {
+				sh.inc(counterReloads, 1)
+				graceValidKeyIndices = append(graceValidKeyIndices, keyIdx)
+				graceValidResults = append(graceValidResults, cachedResult)
+			}
 		default:
 			// Not valid and not even within grace period: query, cache and return:
+			sh.inc(counterMisses, 1)
 			invalidKeyIndices = append(invalidKeyIndices, keyIdx)
 		}
 	}
 
-	// execMultiOpAndCache executes execMultiOp(), caches the results according to the configuration, and returns them
-	execMultiOpAndCache := func(keyIndices []int) (results []T, resultErrs []error) {
-		results, resultErrs = execMultiOp(keyIndices)
-		for i, resultErr := range resultErrs {
-			oc.Set(keys[keyIndices[i]], results[i], resultErr)
+	if len(invalidKeyIndices) > 0 {
+		// Join ongoing op executions of keys others are already producing, and register calls for the rest:
+		calls := make([]*opCall[T], len(invalidKeyIndices))
+		started := make([]bool, len(invalidKeyIndices))
+		var (
+			ownKeyIndices []int // Key indices we have to produce
+			ownCalls      []*opCall[T]
+			owned         = map[*opCall[T]]bool{}
+		)
+		for i, keyIdx := range invalidKeyIndices {
+			calls[i], started[i] = oc.shard(keys[keyIdx]).joinOrStartCall(ctx, keys[keyIdx])
+			if started[i] {
+				ownKeyIndices = append(ownKeyIndices, keyIdx)
+				ownCalls = append(ownCalls, calls[i])
+				owned[calls[i]] = true
+			} else if owned[calls[i]] {
+				// Duplicate key whose call we started: it must get the same result as the first occurrence.
+				started[i] = true
+			}
 		}
-		return
-	}
 
-	if len(invalidKeyIndices) > 0 {
-		// Call execMultiOpAndCache and wait for its results!
-		mresults, mresultErrs := execMultiOpAndCache(invalidKeyIndices)
-		for i, result := range mresults {
-			keyIdx := invalidKeyIndices[i]
-			results[keyIdx], resultErrs[keyIdx] = result, mresultErrs[i]
+		if len(ownKeyIndices) > 0 {
+			if ctx.Done() == nil {
+				// ctx is never cancelled, no need for a new goroutine: execute op in ours.
+				oc.execMultiCall(keys, ownKeyIndices, ownCalls, execMultiOp)
+			} else {
+				go oc.execMultiCall(keys, ownKeyIndices, ownCalls, execMultiOp)
+			}
+		}
+
+		for i, keyIdx := range invalidKeyIndices {
+			key := keys[keyIdx]
+			results[keyIdx], resultErrs[keyIdx] = waitCall(ctx, oc.shard(key), key, calls[i], started[i])
 		}
 	}
 
 	if len(graceValidKeyIndices) > 0 {
-		// Launch background goroutine in which call execMultiOp (we're not waiting for its results)!
+		// reload in new goroutine.
+		// Note: we're not using the return values, we're returning the cached (grace-valid) values.
+		go oc.multiReload(context.WithoutCancel(ctx), keys, graceValidKeyIndices, graceValidResults, execMultiOp)
+	}
 
-		// First let's see which elements we do need to process, and if we're the one to do it:
-		graceValidKeyIndices2 := make([]int, 0, len(graceValidKeyIndices))
-		for _, keyIdx := range graceValidKeyIndices {
-			cachedResult := cachedResults[keyIdx]
+	return
+}
 
-			// First use read-lock to check if someone's already doing it:
+// execMultiOpAndCacheResults executes execMultiOp(), caches the results according to the configuration, and returns them.
+// cached tells for each result if it was cached.
+func (oc *OpCache[K, T]) execMultiOpAndCacheResults(
+	ctx context.Context,
+	keys []K,
+	keyIndices []int,
+	execMultiOp multiLoadFunc[T],
+) (results []T, resultErrs []error, cached []bool) {
 
-			cachedResult.reloadMu.RLock()
-			reloading := cachedResult.reloading
-			cachedResult.reloadMu.RUnlock()
-			if reloading {
-				// Already reloading, nothing to do
-				continue
-			}
+	results, resultErrs, opts := oc.execMultiOpWithStore(ctx, keys, keyIndices, execMultiOp)
 
-			// Try to take ownership of reloading, needs write-lock:
-			cachedResult.reloadMu.Lock()
-			if cachedResult.reloading {
-				// Someone else got the write-lock first, he'll take care of the reload
-				cachedResult.reloadMu.Unlock()
-				continue
-			}
-			cachedResult.reloading = true // We'll be the one to do it
-			cachedResult.reloadMu.Unlock()
-			graceValidKeyIndices2 = append(graceValidKeyIndices2, keyIdx)
-		}
-		if len(graceValidKeyIndices2) > 0 {
-			// reload in new goroutine.
-			// Note: we're not using the return values, we're returning the cached (grace-valid) values.
-			go execMultiOpAndCache(graceValidKeyIndices2)
+	cached = make([]bool, len(keyIndices))
+	if ctx.Err() != nil {
+		// Op execution was cancelled (nobody is waiting for it), don't cache its results.
+		return
+	}
+	for i, resultErr := range resultErrs {
+		entryOpts := entryOptionsAt(opts, i)
+		entryOpts.read = true // The op was executed because the key was read
+		cached[i] = oc.set(keys[keyIndices[i]], results[i], resultErr, entryOpts)
+	}
+	return
+}
+
+// multiLoadFunc is the general form of functions executing a multi-operation for keys designated by keyIndices.
+// opts may be nil.
+type multiLoadFunc[T any] func(ctx context.Context, keyIndices []int) (results []T, opts []EntryOptions, errs []error)
+
+// execMultiOp executes execMultiOp() for keys designated by keyIndices but protects against panics:
+// a panic is turned into an error result (for all keys) like [Protect] does.
+func (oc *OpCache[K, T]) execMultiOp(
+	ctx context.Context,
+	keys []K,
+	keyIndices []int,
+	execMultiOp multiLoadFunc[T],
+) (results []T, opts []EntryOptions, resultErrs []error) {
+
+	oc.inFlight.Add(int64(len(keyIndices)))
+	defer oc.inFlight.Add(-int64(len(keyIndices)))
+
+	start := time.Now()
+	if err := Protect(func() { results, opts, resultErrs = execMultiOp(ctx, keyIndices) }); err != nil {
+		results, opts, resultErrs = make([]T, len(keyIndices)), nil, make([]error, len(keyIndices))
+		for i := range resultErrs {
+			resultErrs[i] = err
 		}
 	}
 
+	if oc.cfg.OnLoad != nil {
+		d := time.Since(start)
+		for i, keyIdx := range keyIndices {
+			oc.cfg.OnLoad(keys[keyIdx], d, resultErrs[i])
+		}
+	}
 	return
 }
 
+// multiReload executes execMultiOp() to refresh the grace-valid results oprs of keys designated by keyIndices
+// (in the background), and caches the results. See [OpCache.reload] for details.
+func (oc *OpCache[K, T]) multiReload(
+	ctx context.Context,
+	keys []K,
+	keyIndices []int,
+	oprs []*opResult[T],
+	execMultiOp multiLoadFunc[T],
+) {
+	results, resultErrs, opts := oc.execMultiOpWithStore(ctx, keys, keyIndices, execMultiOp)
+	for i, resultErr := range resultErrs {
+		if !oc.setReloaded(keys[keyIndices[i]], results[i], resultErr, entryOptionsAt(opts, i), nil) {
+			oprs[i].endReload()
+		}
+	}
+}
+
+// execMultiCall executes execMultiOp for the given calls (one for each key designated by keyIndices),
+// caches and stores the results, and signals their completion.
+//
+// The context passed to execMultiOp is only cancelled when the contexts of all calls are cancelled
+// (no caller is waiting for any of the keys).
+func (oc *OpCache[K, T]) execMultiCall(
+	keys []K,
+	keyIndices []int,
+	calls []*opCall[T],
+	execMultiOp multiLoadFunc[T],
+) {
+	execCtx, cancel := context.WithCancel(context.WithoutCancel(calls[0].ctx))
+	remaining := atomic.Int64{}
+	remaining.Store(int64(len(calls)))
+	for _, call := range calls {
+		stop := context.AfterFunc(call.ctx, func() {
+			if remaining.Add(-1) == 0 {
+				cancel()
+			}
+		})
+		defer stop()
+	}
+
+	// Make sure all calls are completed even if caching the results panics (execMultiOp itself is protected, see execMultiOp).
+	// We may be executed in a new goroutine: recover the panic so it can be re-panicked in the starter's goroutine.
+	completed := 0
+	defer func() {
+		r := recover()
+		for i, call := range calls[completed:] {
+			if r != nil {
+				call.panicked, call.panicVal = true, r
+			}
+			key := keys[keyIndices[completed+i]]
+			oc.shard(key).completeCall(key, call)
+		}
+		cancel()
+	}()
+
+	results, resultErrs, cached := oc.execMultiOpAndCacheResults(execCtx, keys, keyIndices, execMultiOp)
+	for i, call := range calls {
+		call.result, call.resultErr, call.cached = results[i], resultErrs[i], cached[i]
+		key := keys[keyIndices[i]]
+		oc.shard(key).completeCall(key, call)
+		completed++
+	}
+}
+
 // opResult holds the result of an operation.
 type opResult[T any] struct {
 	expiresAt, graceExpiresAt time.Time
@@ -392,27 +1075,64 @@ type opResult[T any] struct {
 	result    T // If an op has multiple results, this should be a slice (e.g. []any)
 	resultErr error
 
+	tags   []string
+	weight int64 // Weight of the entry, see OpCache.WithWeigher
+
+	loader     loadFunc[T]  // Loader to refresh the entry with, see OpCacheConfig.RefreshAhead
+	lastAccess atomic.Int64 // Unix nanos of the last read (0 if never read), only tracked if refreshing is enabled
+
 	reloadMu  sync.RWMutex
 	reloading bool
 }
 
 // newOpResult creates a new OpResult.
-func newOpResult[T any](result T, resultErr error, expiration, graceExpiration time.Duration) *opResult[T] {
-	now := time.Now()
-	return &opResult[T]{
+func newOpResult[T any](now time.Time, result T, resultErr error, expiration, graceExpiration time.Duration) *opResult[T] {
+	opr := &opResult[T]{
 		expiresAt:      now.Add(expiration),
 		graceExpiresAt: now.Add(expiration + graceExpiration),
 		result:         result,
 		resultErr:      resultErr,
 	}
+	return opr
+}
+
+// valid tells if the result is valid at the given time.
+func (opr *opResult[T]) valid(now time.Time) bool {
+	return opr != nil && now.Before(opr.expiresAt)
+}
+
+// graceValid tells if the result is "grace-valid" (valid within the grace expiration beyond the normal expiration)
+// at the given time.
+func (opr *opResult[T]) graceValid(now time.Time) bool {
+	return opr != nil && now.Before(opr.graceExpiresAt)
 }
 
-// valid tells if the result is valid.
-func (opr *opResult[T]) valid() bool {
-	return opr != nil && time.Now().Before(opr.expiresAt)
+// claimReload tries to take ownership of reloading the result.
+// Returns true if the caller is the one to reload it, false if someone's already doing it.
+func (opr *opResult[T]) claimReload() bool {
+	// First use read-lock to check if someone's already doing it:
+	opr.reloadMu.RLock()
+	reloading := opr.reloading
+	opr.reloadMu.RUnlock()
+	if reloading {
+		// Already reloading, nothing to do
+		return false
+	}
+
+	// Try to take ownership of reloading, needs write-lock:
+	opr.reloadMu.Lock()
+	defer opr.reloadMu.Unlock()
+	if opr.reloading {
+		// Someone else got the write-lock first, he'll take care of the reload
+		return false
+	}
+	opr.reloading = true // We'll be the one to do it
+	return true
 }
 
-// graceValid tells if the result is "grace-valid" (valid within the grace expiration beyond the normal expiration).
-func (opr *opResult[T]) graceValid() bool {
-	return opr != nil && time.Now().Before(opr.graceExpiresAt)
+// endReload resets the reloading flag, so reloading may be claimed again.
+func (opr *opResult[T]) endReload() {
+	opr.reloadMu.Lock()
+	opr.reloading = false
+	opr.reloadMu.Unlock()
 }
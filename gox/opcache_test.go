@@ -1,8 +1,15 @@
 package gox
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"expvar"
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -253,3 +260,1186 @@ func TestOpCacheSetClearRemove(t *testing.T) {
 		t.Errorf("Expected %d, got: %d", exp, got)
 	}
 }
+
+func TestOpCacheMaxEntries(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   EvictionPolicy
+		accesses []int // Keys to get in order
+		cached   []int // Keys expected to be cached in the end
+		evicted  []int // Keys expected to be evicted in the end
+	}{
+		{"LRU", EvictionLRU, []int{1, 2, 3, 1, 4}, []int{1, 3, 4}, []int{2}},
+		{"LFU", EvictionLFU, []int{1, 1, 2, 2, 3, 4}, []int{1, 2, 4}, []int{3}},
+		{"LRU-scan", EvictionLRU, []int{1, 1, 1, 2, 2, 2, 3, 4, 5}, []int{3, 4, 5}, []int{1, 2}},
+		{"TinyLFU-scan", EvictionTinyLFU, []int{1, 1, 1, 2, 2, 2, 3, 4, 5}, []int{1, 2, 5}, []int{3, 4}},
+	}
+
+	for _, c := range cases {
+		opc := NewOpCache[int, int](OpCacheConfig{
+			ResultExpiration:       time.Minute,
+			AutoEvictPeriodMinutes: -1,
+			MaxEntries:             3,
+			EvictionPolicy:         c.policy,
+		})
+
+		for _, key := range c.accesses {
+			opc.Get(key, func() (int, error) { return key, nil })
+		}
+
+		if got := opc.Stats().Entries; got != 3 {
+			t.Errorf("[%s] Expected %d entries, got: %d", c.name, 3, got)
+		}
+		for _, key := range c.cached {
+			if opc.getCachedOpResult(key) == nil {
+				t.Errorf("[%s] Expected key %d to be cached", c.name, key)
+			}
+		}
+		for _, key := range c.evicted {
+			if opc.getCachedOpResult(key) != nil {
+				t.Errorf("[%s] Expected key %d to be evicted", c.name, key)
+			}
+		}
+	}
+}
+
+func TestOpCacheGetCtx(t *testing.T) {
+	opc := NewOpCache[string, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+	})
+
+	started := make(chan struct{})
+	execOpCancelled := make(chan struct{})
+	execOp := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(execOpCancelled)
+		return 0, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+
+	errCh1, errCh2 := make(chan error), make(chan error)
+	go func() {
+		_, err := opc.GetCtx(ctx1, "1", execOp)
+		errCh1 <- err
+	}()
+	<-started
+	go func() {
+		_, err := opc.GetCtx(ctx2, "1", func(ctx context.Context) (int, error) {
+			t.Error("Second execOp must not be called")
+			return 0, nil
+		})
+		errCh2 <- err
+	}()
+
+	// Wait for the second caller to join:
+	for {
+		opc.shards[0].keyCallsMu.Lock()
+		waiters := opc.shards[0].keyCalls["1"].waiters
+		opc.shards[0].keyCallsMu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel1()
+	if err := <-errCh1; err != context.Canceled {
+		t.Errorf("Expected %v, got: %v", context.Canceled, err)
+	}
+	select {
+	case <-execOpCancelled:
+		t.Errorf("execOp cancelled while a waiter remains")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errCh2; err != context.Canceled {
+		t.Errorf("Expected %v, got: %v", context.Canceled, err)
+	}
+	<-execOpCancelled
+
+	// Result of the cancelled execOp must not be cached:
+	got, err := opc.GetCtx(context.Background(), "1", func(ctx context.Context) (int, error) { return 2, nil })
+	if got != 2 || err != nil {
+		t.Errorf("Expected (%v, %v), got (%v, %v)", 2, nil, got, err)
+	}
+}
+
+func TestOpCacheGetCtxPanic(t *testing.T) {
+	opc := NewOpCache[string, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		ErrorExpiration: func(err error) (discard bool, expiration, graceExpiration *time.Duration) {
+			panic("hook")
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Panics of hooks must be propagated to the starter even if execOp is executed in a new goroutine,
+	// and concurrent waiters must get an error:
+	waiterErrCh := make(chan error)
+	func() {
+		defer func() {
+			if r := recover(); r != "hook" {
+				t.Errorf("Expected panic %v, got: %v", "hook", r)
+			}
+		}()
+		opc.GetCtx(ctx, "1", func(context.Context) (int, error) {
+			go func() {
+				_, err := opc.GetCtx(ctx, "1", func(ctx context.Context) (int, error) { return 0, nil })
+				waiterErrCh <- err
+			}()
+			for {
+				opc.shards[0].keyCallsMu.Lock()
+				waiters := opc.shards[0].keyCalls["1"].waiters
+				opc.shards[0].keyCallsMu.Unlock()
+				if waiters == 2 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			return 0, errors.New("failed")
+		})
+	}()
+	var pe *PanicError
+	if err := <-waiterErrCh; !errors.Is(err, ErrExecOpFailedAndErrDiscarded) || !errors.As(err, &pe) || pe.Value != "hook" {
+		t.Errorf("Expected discarded panic error, got: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "hook" {
+				t.Errorf("Expected panic %v, got: %v", "hook", r)
+			}
+		}()
+		opc.MultiGetCtx(ctx, []string{"2"}, func(ctx context.Context, keyIndices []int) ([]int, []error) {
+			return []int{0}, []error{errors.New("failed")}
+		})
+	}()
+}
+
+func TestOpCacheStats(t *testing.T) {
+	errToDiscard := errors.New("err-to-discard")
+	metrics := new(expvar.Map).Init()
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		MaxEntries:             2,
+		ErrorExpiration: func(err error) (discard bool, expiration, graceExpiration *time.Duration) {
+			return true, nil, nil
+		},
+		Metrics: metrics,
+	})
+
+	for _, key := range []int{1, 1, 2, 3, 3} {
+		opc.Get(key, func() (int, error) { return key, nil })
+	}
+	opc.Get(4, func() (int, error) { return 0, errToDiscard })
+	opc.MultiGet([]int{2, 3}, func(keyIndices []int) ([]int, []error) {
+		return make([]int, len(keyIndices)), make([]error, len(keyIndices))
+	})
+
+	exp := OpCacheStats{Hits: 4, Misses: 4, DiscardedErrors: 1, Evictions: 1, Entries: 2}
+	if got := opc.Stats(); got != exp {
+		t.Errorf("Expected %+v, got: %+v", exp, got)
+	}
+	if got := metrics.Get("misses").String(); got != "4" {
+		t.Errorf("Expected %v, got: %v", 4, got)
+	}
+	if got := metrics.Get("discardedErrors").String(); got != "1" {
+		t.Errorf("Expected %v, got: %v", 1, got)
+	}
+
+	// Metrics keys must match JSON names of the stats:
+	data, _ := json.Marshal(exp)
+	var jsonStats map[string]any
+	json.Unmarshal(data, &jsonStats)
+	for _, name := range opCacheCounterNames {
+		if _, ok := jsonStats[name]; !ok {
+			t.Errorf("Counter %q is not a JSON name of stats: %s", name, data)
+		}
+	}
+
+	buf := &strings.Builder{}
+	if err := WriteOpCacheStatsPrometheus(buf, map[string]OpCacheStats{"test": exp}); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if line := `gox_opcache_misses_total{cache="test"} 4`; !strings.Contains(buf.String(), line) {
+		t.Errorf("Expected line %q in output:\n%s", line, buf)
+	}
+}
+
+func TestOpCacheSnapshotRestore(t *testing.T) {
+	type Key = Struct2[string, int]
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, codec := range []Codec{GobCodec, JSONCodec} {
+		opc := NewOpCache[Key, []string](OpCacheConfig{
+			ResultExpiration:       time.Minute,
+			AutoEvictPeriodMinutes: -1,
+			Clock:                  fc,
+		})
+		opc.Set(Struct2Of("a", 1), []string{"x", "y"}, nil)
+		opc.Set(Struct2Of("b", 2), []string{"z"}, nil)
+		opc.Set(Struct2Of("c", 3), nil, errors.New("not written"))
+		fc.Advance(30 * time.Second)
+		opc.Set(Struct2Of("d", 4), nil, nil)
+
+		buf := &bytes.Buffer{}
+		n, err := opc.Snapshot(buf, codec)
+		if n != 3 || err != nil {
+			t.Errorf("[%T] Expected (%v, %v), got (%v, %v)", codec, 3, nil, n, err)
+		}
+
+		fc.Advance(45 * time.Second) // Let "a" and "b" expire
+
+		opc2 := NewOpCache[Key, []string](OpCacheConfig{AutoEvictPeriodMinutes: -1, Clock: fc})
+		n, err = opc2.Restore(buf, codec)
+		if n != 1 || err != nil {
+			t.Errorf("[%T] Expected (%v, %v), got (%v, %v)", codec, 1, nil, n, err)
+		}
+
+		got, err := opc2.Get(Struct2Of("d", 4), func() ([]string, error) { return []string{"reloaded"}, nil })
+		if got != nil || err != nil {
+			t.Errorf("[%T] Expected (%v, %v), got (%v, %v)", codec, nil, nil, got, err)
+		}
+		if opr := opc2.getCachedOpResult(Struct2Of("d", 4)); !opr.expiresAt.Equal(opc.shards[0].keyResults[Struct2Of("d", 4)].expiresAt) {
+			t.Errorf("[%T] Expected restored expiresAt", codec)
+		}
+	}
+}
+
+// waitOpCacheReloaded waits until the entry of key is not being reloaded.
+// Note: waiting for Stats().InFlight to drop to 0 is not enough, the result is cached after that.
+func waitOpCacheReloaded[K comparable, T any](oc *OpCache[K, T], key K) {
+	for {
+		if entry, ok := oc.Peek(key); !ok || !entry.Reloading {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOpCacheClock(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	opc := NewOpCache[string, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		ResultGraceExpiration:  time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		Clock:                  fc,
+	})
+
+	var counter int64
+	reloaded := make(chan struct{}, 1)
+	operation := func() (int, error) {
+		defer func() { reloaded <- struct{}{} }()
+		return int(atomic.AddInt64(&counter, 1)), nil
+	}
+
+	cases := []struct {
+		name    string
+		advance time.Duration
+		result  int
+		reload  bool // Tells if a (background) op execution is expected
+	}{
+		{"0: not cached, operation() called", 0, 1, true},
+		{"1: cached, valid", 59 * time.Second, 1, false},
+		{"2: grace-valid, operation() called in background", time.Minute, 1, true},
+		{"3: value loaded in background", 0, 2, false},
+		{"4: invalid, operation() called", 2 * time.Minute, 3, true},
+	}
+
+	for _, c := range cases {
+		fc.Advance(c.advance)
+		if got, _ := opc.Get("1", operation); got != c.result {
+			t.Errorf("[%s] Expected %v, got: %v", c.name, c.result, got)
+		}
+		if c.reload {
+			<-reloaded
+			// Wait for the (background) result to be cached:
+			waitOpCacheReloaded(opc, "1")
+		}
+	}
+
+	fc.Advance(2 * time.Minute)
+	stopCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunEvictorClock(stopCtx, fc, time.Minute, opc)
+		close(done)
+	}()
+	for opc.Stats().Entries > 0 {
+		fc.Advance(time.Minute) // Ticker may not be created yet, keep advancing
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestOpCacheEvictorClose(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	evictor := NewEvictor(fc)
+
+	newOpCache := func() *OpCache[string, int] {
+		return NewOpCache[string, int](OpCacheConfig{
+			ResultExpiration:       time.Minute,
+			AutoEvictPeriodMinutes: 1,
+			Clock:                  fc,
+			Evictor:                evictor,
+		})
+	}
+	opc1, opc2 := newOpCache(), newOpCache()
+	if got := evictor.Len(); got != 2 {
+		t.Errorf("Expected %d, got: %d", 2, got)
+	}
+
+	opc1.Set("1", 1, nil)
+	opc2.Set("2", 2, nil)
+	fc.Advance(2 * time.Minute)
+	for opc1.Stats().Entries > 0 || opc2.Stats().Entries > 0 {
+		time.Sleep(time.Millisecond) // Wait for the evictor goroutine
+	}
+
+	opc1.Close()
+	if got := evictor.Len(); got != 1 || !evictor.running() {
+		t.Errorf("Expected %d running, got: %d, %t", 1, got, evictor.running())
+	}
+	opc2.Close()
+	if got := evictor.Len(); got != 0 || evictor.running() {
+		t.Errorf("Expected %d not running, got: %d, %t", 0, got, evictor.running())
+	}
+
+	// Evictor must restart on demand:
+	opc3 := newOpCache()
+	defer opc3.Close()
+	if !evictor.running() {
+		t.Errorf("Expected running evictor")
+	}
+}
+
+func TestOpCacheSharded(t *testing.T) {
+	type Key = Struct2[string, int]
+	opc := NewOpCache[Key, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		ShardCount:             8,
+	})
+	if got := len(opc.shards); got != 8 {
+		t.Errorf("Expected %d shards, got: %d", 8, got)
+	}
+
+	for i := range 100 {
+		if got, _ := opc.Get(Struct2Of("k", i), func() (int, error) { return i, nil }); got != i {
+			t.Errorf("Expected %d, got: %d", i, got)
+		}
+	}
+	if got := opc.Stats(); got.Entries != 100 || got.Misses != 100 {
+		t.Errorf("Expected %d entries and misses, got: %+v", 100, got)
+	}
+
+	// Entries must be distributed among shards:
+	for i, sh := range opc.shards {
+		if sh.len() == 0 {
+			t.Errorf("Expected entries in shard %d", i)
+		}
+	}
+
+	keys := []Key{Struct2Of("k", 1), Struct2Of("k", 200), Struct2Of("k", 3)}
+	results, _ := opc.MultiGet(keys, func(keyIndices []int) ([]int, []error) {
+		if len(keyIndices) != 1 || keyIndices[0] != 1 {
+			t.Errorf("Expected key indices %v, got: %v", []int{1}, keyIndices)
+		}
+		return []int{200}, []error{nil}
+	})
+	if exp := []int{1, 200, 3}; !slices.Equal(results, exp) {
+		t.Errorf("Expected %v, got: %v", exp, results)
+	}
+
+	opc.Remove(keys...)
+	if got := opc.Stats().Entries; got != 98 {
+		t.Errorf("Expected %d entries, got: %d", 98, got)
+	}
+}
+
+func TestOpCacheShardedMaxEntries(t *testing.T) {
+	for _, c := range []struct{ maxEntries, shardCount, expShards int }{
+		{10, 64, 10},
+		{10, 4, 4},
+		{100, 8, 8},
+	} {
+		opc := NewOpCache[int, int](OpCacheConfig{
+			ResultExpiration:       time.Minute,
+			AutoEvictPeriodMinutes: -1,
+			MaxEntries:             c.maxEntries,
+			ShardCount:             c.shardCount,
+		})
+		if got := len(opc.shards); got != c.expShards {
+			t.Errorf("[%+v] Expected %d shards, got: %d", c, c.expShards, got)
+		}
+		total := 0
+		for _, sh := range opc.shards {
+			total += sh.maxEntries
+		}
+		if total != c.maxEntries {
+			t.Errorf("[%+v] Expected shard limits to add up to %d, got: %d", c, c.maxEntries, total)
+		}
+
+		for i := range 1000 {
+			opc.Set(i, i, nil)
+		}
+		if got := opc.Len(); got > c.maxEntries {
+			t.Errorf("[%+v] Expected at most %d entries, got: %d", c, c.maxEntries, got)
+		}
+	}
+}
+
+// BenchmarkOpCacheGet measures concurrent Get calls served from the cache.
+// Compare results of different shard counts using multiple CPUs (e.g. -cpu=1,8,64),
+// sharding reduces lock contention as the number of CPUs grows.
+func BenchmarkOpCacheGet(b *testing.B) {
+	const keysCount = 1024
+
+	for _, maxEntries := range []int{0, 2 * keysCount} {
+		for _, shardCount := range []int{1, 64} {
+			b.Run(fmt.Sprintf("maxEntries=%d/shards=%d", maxEntries, shardCount), func(b *testing.B) {
+				opc := NewOpCache[int, int](OpCacheConfig{
+					ResultExpiration:       time.Hour,
+					AutoEvictPeriodMinutes: -1,
+					MaxEntries:             maxEntries,
+					ShardCount:             shardCount,
+				})
+				for i := range keysCount {
+					opc.Set(i, i, nil)
+				}
+				execOp := func() (int, error) { return 0, nil }
+
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						opc.Get(i%keysCount, execOp)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestOpCacheMultiGetInFlight(t *testing.T) {
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+	})
+
+	var (
+		mu       sync.Mutex
+		produced []int // Keys passed to execMultiOp
+	)
+	release := make(chan struct{})
+	execMultiOp := func(keys []int) func(keyIndices []int) ([]int, []error) {
+		return func(keyIndices []int) ([]int, []error) {
+			mu.Lock()
+			for _, keyIdx := range keyIndices {
+				produced = append(produced, keys[keyIdx])
+			}
+			mu.Unlock()
+			<-release
+			results := make([]int, len(keyIndices))
+			for i, keyIdx := range keyIndices {
+				results[i] = keys[keyIdx] * 10
+			}
+			return results, make([]error, len(keyIndices))
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	multiGet := func(keys []int) {
+		wg.Go(func() {
+			results, _ := opc.MultiGet(keys, execMultiOp(keys))
+			for i, key := range keys {
+				if results[i] != key*10 {
+					t.Errorf("Expected %d, got: %d", key*10, results[i])
+				}
+			}
+		})
+	}
+	waitForCalls := func(n int) {
+		for {
+			opc.shards[0].keyCallsMu.Lock()
+			calls := len(opc.shards[0].keyCalls)
+			opc.shards[0].keyCallsMu.Unlock()
+			if calls == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	multiGet([]int{1, 2})
+	waitForCalls(2)
+	multiGet([]int{2, 3, 3})
+	waitForCalls(3)
+	wg.Go(func() {
+		if got, _ := opc.Get(1, func() (int, error) { t.Error("Unexpected execOp call"); return 0, nil }); got != 10 {
+			t.Errorf("Expected %d, got: %d", 10, got)
+		}
+	})
+
+	close(release)
+	wg.Wait()
+
+	slices.Sort(produced)
+	if exp := []int{1, 2, 3}; !slices.Equal(produced, exp) {
+		t.Errorf("Expected produced keys %v, got: %v", exp, produced)
+	}
+}
+
+func TestOpCacheMultiGetDuplicateKeys(t *testing.T) {
+	opc := NewOpCache[string, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		ErrorExpiration: func(err error) (discard bool, expiration, graceExpiration *time.Duration) {
+			return true, nil, nil
+		},
+	})
+
+	// Duplicate keys must be produced once, and get the same (non-cached) result:
+	testErr := errors.New("test")
+	var produced []int
+	_, errs := opc.MultiGet([]string{"a", "b", "a"}, func(keyIndices []int) ([]int, []error) {
+		produced = append(produced, keyIndices...)
+		return make([]int, len(keyIndices)), []error{testErr, testErr}
+	})
+	if exp := []int{0, 1}; !slices.Equal(produced, exp) {
+		t.Errorf("Expected produced key indices %v, got: %v", exp, produced)
+	}
+	for i, err := range errs {
+		if err != testErr {
+			t.Errorf("[%d] Expected error %v, got: %v", i, testErr, err)
+		}
+	}
+}
+
+func TestOpCachePanic(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	opc := NewOpCache[string, int](OpCacheConfig{
+		ResultExpiration:        time.Minute,
+		ResultGraceExpiration:   time.Minute,
+		AutoEvictPeriodMinutes:  -1,
+		Clock:                   fc,
+		KeepResultOnReloadError: true,
+		ErrorExpiration: func(err error) (discard bool, expiration, graceExpiration *time.Duration) {
+			return true, nil, nil
+		},
+	})
+
+	// Panic during first load, with a concurrent waiter:
+	started, release := make(chan struct{}), make(chan struct{})
+	waiterErrCh := make(chan error)
+	go func() {
+		<-started
+		go func() {
+			_, err := opc.Get("1", func() (int, error) { return 0, nil })
+			waiterErrCh <- err
+		}()
+		for {
+			opc.shards[0].keyCallsMu.Lock()
+			waiters := opc.shards[0].keyCalls["1"].waiters
+			opc.shards[0].keyCallsMu.Unlock()
+			if waiters == 2 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+	}()
+	_, err := opc.Get("1", func() (int, error) {
+		close(started)
+		<-release
+		panic("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected recovered panic error, got: %v", err)
+	}
+	if err := <-waiterErrCh; !errors.Is(err, ErrExecOpFailedAndErrDiscarded) || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected discarded panic error, got: %v", err)
+	}
+
+	// Panic during background reload:
+	opc.Set("2", 2, nil)
+	fc.Advance(90 * time.Second)
+	reloaded := make(chan struct{})
+	if got, _ := opc.Get("2", func() (int, error) { defer close(reloaded); panic("boom") }); got != 2 {
+		t.Errorf("Expected %d, got: %d", 2, got)
+	}
+	<-reloaded
+	waitOpCacheReloaded(opc, "2")
+
+	// Grace-valid result must be kept, and reload must be retried:
+	reloaded = make(chan struct{})
+	if got, _ := opc.Get("2", func() (int, error) { defer close(reloaded); return 3, nil }); got != 2 {
+		t.Errorf("Expected %d, got: %d", 2, got)
+	}
+	<-reloaded
+	waitOpCacheReloaded(opc, "2")
+	if got, _ := opc.Get("2", nil); got != 3 {
+		t.Errorf("Expected %d, got: %d", 3, got)
+	}
+}
+
+func TestOpCacheEntryOptions(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:        time.Minute,
+		AutoEvictPeriodMinutes:  -1,
+		Clock:                   fc,
+		ExpirationJitterPercent: 10,
+	})
+
+	ctx := context.Background()
+	got, _ := opc.GetWithOptions(ctx, 1, func(ctx context.Context) (int, EntryOptions, error) {
+		return 1, EntryOptions{Expiration: Ptr(10 * time.Second)}, nil
+	})
+	if got != 1 {
+		t.Errorf("Expected %d, got: %d", 1, got)
+	}
+	opc.SetWithOptions(2, 2, nil, EntryOptions{Expiration: Ptr(time.Hour)})
+
+	fc.Advance(20 * time.Second)
+	if got := opc.getCachedOpResult(1); got.valid(fc.Now()) {
+		t.Errorf("Expected expired entry")
+	}
+	fc.Advance(30 * time.Minute)
+	if got := opc.getCachedOpResult(2); !got.valid(fc.Now()) {
+		t.Errorf("Expected valid entry")
+	}
+
+	// Jitter:
+	now := fc.Now()
+	expiresAts := map[time.Time]bool{}
+	for i := range 100 {
+		opc.Set(i, i, nil)
+		expiresAt := opc.getCachedOpResult(i).expiresAt
+		if expiresAt.Before(now.Add(54*time.Second)) || expiresAt.After(now.Add(66*time.Second)) {
+			t.Errorf("Expiration out of jitter range: %v", expiresAt.Sub(now))
+		}
+		expiresAts[expiresAt] = true
+	}
+	if len(expiresAts) < 50 {
+		t.Errorf("Expected randomized expirations, got %d distinct", len(expiresAts))
+	}
+}
+
+func TestOpCacheIntrospection(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	errTest := errors.New("test")
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		Clock:                  fc,
+		ShardCount:             4,
+	})
+
+	if _, ok := opc.Peek(1); ok {
+		t.Errorf("Expected not cached")
+	}
+
+	for i := range 10 {
+		opc.Set(i, i*10, If(i == 9, errTest, nil))
+	}
+
+	entry, ok := opc.Peek(9)
+	exp := OpCacheEntry[int]{Result: 90, Err: errTest, ExpiresAt: fc.Now().Add(time.Minute), GraceExpiresAt: fc.Now().Add(time.Minute)}
+	if !ok || entry != exp || !entry.IsError() {
+		t.Errorf("Expected %+v, got: %+v", exp, entry)
+	}
+	if got := opc.Stats(); got.Hits != 0 || got.Misses != 0 {
+		t.Errorf("Peek must not count as an access, got: %+v", got)
+	}
+
+	if got := opc.Len(); got != 10 {
+		t.Errorf("Expected %d, got: %d", 10, got)
+	}
+
+	keys := slices.Sorted(opc.Keys())
+	if exp := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+
+	// Remove errors while ranging:
+	for key, entry := range opc.Range() {
+		if entry.Result != key*10 {
+			t.Errorf("Expected %d, got: %d", key*10, entry.Result)
+		}
+		if entry.IsError() {
+			opc.Remove(key)
+		}
+	}
+	if got := opc.Len(); got != 9 {
+		t.Errorf("Expected %d, got: %d", 9, got)
+	}
+}
+
+func TestOpCacheTags(t *testing.T) {
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		ShardCount:             4,
+	})
+
+	for i := range 10 {
+		tags := []string{If(i%2 == 0, "even", "odd")}
+		if i%3 == 0 {
+			tags = append(tags, "three")
+		}
+		opc.SetWithOptions(i, i, nil, EntryOptions{Tags: tags})
+	}
+	opc.GetWithOptions(context.Background(), 10, func(ctx context.Context) (int, EntryOptions, error) {
+		return 10, EntryOptions{Tags: []string{"even"}}, nil
+	})
+
+	if n := opc.RemoveByTag("three"); n != 4 { // 0, 3, 6, 9
+		t.Errorf("Expected %d, got: %d", 4, n)
+	}
+	if n := opc.RemoveByTag("even", "missing"); n != 4 { // 2, 4, 8, 10
+		t.Errorf("Expected %d, got: %d", 4, n)
+	}
+	if keys, exp := slices.Sorted(opc.Keys()), []int{1, 5, 7}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+
+	// Re-setting an entry must replace its tags:
+	opc.SetWithOptions(1, 1, nil, EntryOptions{Tags: []string{"new"}})
+	if n := opc.RemoveByTag("odd"); n != 2 {
+		t.Errorf("Expected %d, got: %d", 2, n)
+	}
+
+	if n := opc.RemoveFunc(func(key int, entry OpCacheEntry[int]) bool { return entry.Result == 1 }); n != 1 {
+		t.Errorf("Expected %d, got: %d", 1, n)
+	}
+	if n := opc.Len(); n != 0 {
+		t.Errorf("Expected %d, got: %d", 0, n)
+	}
+	for _, sh := range opc.shards {
+		if len(sh.tagKeys) != 0 {
+			t.Errorf("Expected empty tag index, got: %v", sh.tagKeys)
+		}
+	}
+
+	// Tags must survive a snapshot round-trip:
+	opc.SetWithOptions(1, 1, nil, EntryOptions{Tags: []string{"odd"}})
+	opc.SetWithOptions(2, 2, nil, EntryOptions{Tags: []string{"even"}})
+	buf := &bytes.Buffer{}
+	if _, err := opc.Snapshot(buf, GobCodec); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+	opc2 := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		ShardCount:             4,
+	})
+	if n, err := opc2.Restore(buf, GobCodec); n != 2 || err != nil {
+		t.Fatalf("Expected %d restored entries, got: %d, %v", 2, n, err)
+	}
+	if n := opc2.RemoveByTag("odd"); n != 1 {
+		t.Errorf("Expected %d, got: %d", 1, n)
+	}
+	if keys, exp := slices.Collect(opc2.Keys()), []int{2}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+}
+
+func TestOpCacheMultiGetWithOptions(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		Clock:                  fc,
+		ShardCount:             4,
+	})
+
+	results, _ := opc.MultiGetWithOptions(context.Background(), []int{1, 2, 3}, func(ctx context.Context, keyIndices []int) ([]int, []EntryOptions, []error) {
+		return []int{1, 2, 3}, []EntryOptions{
+			{Tags: []string{"odd"}, Expiration: Ptr(time.Hour)},
+			{Tags: []string{"even"}},
+			{Tags: []string{"odd"}},
+		}, make([]error, len(keyIndices))
+	})
+	if exp := []int{1, 2, 3}; !slices.Equal(results, exp) {
+		t.Errorf("Expected %v, got: %v", exp, results)
+	}
+	if entry, _ := opc.Peek(1); !entry.ExpiresAt.Equal(fc.Now().Add(time.Hour)) {
+		t.Errorf("Expected expiration override, got: %v", entry.ExpiresAt)
+	}
+	if n := opc.RemoveByTag("odd"); n != 2 {
+		t.Errorf("Expected %d, got: %d", 2, n)
+	}
+
+	// nil opts:
+	opc.MultiGetWithOptions(context.Background(), []int{4}, func(ctx context.Context, keyIndices []int) ([]int, []EntryOptions, []error) {
+		return []int{4}, nil, []error{nil}
+	})
+	if keys, exp := slices.Sorted(opc.Keys()), []int{2, 4}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+}
+
+func TestOpCacheStore(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	fileStore, err := NewFileStore(t.TempDir(), fc)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	for _, store := range []OpCacheStore{NewMemoryStore(fc), fileStore} {
+		var instances [2]*OpCache[int, int]
+		for i := range instances {
+			instances[i] = NewOpCache[int, int](OpCacheConfig{
+				ResultExpiration:       time.Minute,
+				AutoEvictPeriodMinutes: -1,
+				Clock:                  fc,
+				Store:                  store,
+				OnStoreError:           func(key any, err error) { t.Errorf("Unexpected store error for %v: %v", key, err) },
+				OnInvalidate: func(keys []any) {
+					// Broadcast to the other instance:
+					for _, key := range keys {
+						instances[1-i].RemoveLocal(key.(int))
+					}
+				},
+			})
+		}
+		a, b := instances[0], instances[1]
+
+		calls := 0
+		execOp := func(key int) func() (int, error) {
+			return func() (int, error) {
+				calls++
+				return key * 10, nil
+			}
+		}
+
+		if result, _ := a.Get(1, execOp(1)); result != 10 || calls != 1 {
+			t.Errorf("Expected %d (%d calls), got: %d (%d calls)", 10, 1, result, calls)
+		}
+		fc.Advance(30 * time.Second)
+		// b must get it from the store, keeping its expiration:
+		if result, _ := b.Get(1, execOp(1)); result != 10 || calls != 1 {
+			t.Errorf("Expected %d (%d calls), got: %d (%d calls)", 10, 1, result, calls)
+		}
+		if entry, _ := b.Peek(1); !entry.ExpiresAt.Equal(fc.Now().Add(30 * time.Second)) {
+			t.Errorf("Expected expiration %v, got: %v", fc.Now().Add(30*time.Second), entry.ExpiresAt)
+		}
+
+		// MultiGet must also use the store:
+		results, _ := b.MultiGet([]int{1, 2}, func(keyIndices []int) ([]int, []error) {
+			if !slices.Equal(keyIndices, []int{1}) {
+				t.Errorf("Expected %v, got: %v", []int{1}, keyIndices)
+			}
+			return []int{20}, make([]error, 1)
+		})
+		if !slices.Equal(results, []int{10, 20}) {
+			t.Errorf("Expected %v, got: %v", []int{10, 20}, results)
+		}
+		if result, _ := a.Get(2, execOp(2)); result != 20 || calls != 1 {
+			t.Errorf("Expected %d (%d calls), got: %d (%d calls)", 20, 1, result, calls)
+		}
+
+		// Invalidation must remove from the store and from the other instance:
+		b.Remove(1)
+		if _, ok := a.Peek(1); ok {
+			t.Errorf("Expected invalidated")
+		}
+		if result, _ := a.Get(1, execOp(1)); result != 10 || calls != 2 {
+			t.Errorf("Expected %d (%d calls), got: %d (%d calls)", 10, 2, result, calls)
+		}
+
+		// Expired store entries must not be used:
+		fc.Advance(2 * time.Minute)
+		if result, _ := b.Get(2, execOp(2)); result != 20 || calls != 3 {
+			t.Errorf("Expected %d (%d calls), got: %d (%d calls)", 20, 3, result, calls)
+		}
+	}
+}
+
+func TestOpCacheMaxWeight(t *testing.T) {
+	opc := NewOpCache[string, string](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		MaxWeight:              10,
+	}).WithWeigher(func(key, result string) int64 { return int64(len(result)) })
+
+	opc.Set("a", "1234", nil)
+	opc.Set("b", "1234", nil)
+	opc.Get("a", func() (string, error) { return "", nil }) // Make "a" recently used
+	opc.Set("c", "12", nil)
+	if got := opc.Stats(); got.Weight != 10 || got.Entries != 3 || got.Evictions != 0 {
+		t.Errorf("Expected weight %d, %d entries, %d evictions, got: %+v", 10, 3, 0, got)
+	}
+
+	// Exceeding the limit must evict the least recently used:
+	opc.Set("d", "1", nil)
+	if keys, exp := slices.Sorted(opc.Keys()), []string{"a", "c", "d"}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+	if got := opc.Stats(); got.Weight != 7 || got.Evictions != 1 {
+		t.Errorf("Expected weight %d, %d evictions, got: %+v", 7, 1, got)
+	}
+
+	// Replacing an entry must update the weight:
+	opc.Set("c", "123456", nil) // Exceeds the limit, "a" is the least recently used
+	if keys, exp := slices.Sorted(opc.Keys()), []string{"c", "d"}; !slices.Equal(keys, exp) {
+		t.Errorf("Expected %v, got: %v", exp, keys)
+	}
+	if got := opc.Stats(); got.Weight != 7 {
+		t.Errorf("Expected weight %d, got: %+v", 7, got)
+	}
+
+	// An entry heavier than the limit must not stay:
+	opc.Set("e", "12345678901", nil)
+	if _, ok := opc.Peek("e"); ok {
+		t.Errorf("Expected not cached")
+	}
+
+	opc.Remove("d")
+	if got := opc.Stats(); got.Weight != 6 {
+		t.Errorf("Expected weight %d, got: %+v", 6, got)
+	}
+	opc.Clear()
+	if got := opc.Stats(); got.Weight != 0 {
+		t.Errorf("Expected weight %d, got: %+v", 0, got)
+	}
+}
+
+func TestOpCacheMaxWeightSharded(t *testing.T) {
+	opc := NewOpCache[int, string](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		MaxWeight:              100,
+		ShardCount:             4,
+	}).WithWeigher(func(key int, result string) int64 { return int64(len(result)) })
+
+	// An entry heavier than a shard's share of MaxWeight must be kept:
+	opc.Set(0, strings.Repeat("x", 30), nil)
+	if _, ok := opc.Peek(0); !ok {
+		t.Errorf("Expected cached")
+	}
+	if got := opc.Stats(); got.Weight != 30 || got.Evictions != 0 {
+		t.Errorf("Expected weight %d, %d evictions, got: %+v", 30, 0, got)
+	}
+
+	// The total weight must not exceed MaxWeight:
+	for i := 1; i < 100; i++ {
+		opc.Set(i, strings.Repeat("x", 1+i%30), nil)
+		if got := opc.Stats(); got.Weight > 100 || got.Weight != opc.weight.Load() {
+			t.Fatalf("Expected weight at most %d (%d), got: %+v", 100, opc.weight.Load(), got)
+		}
+	}
+	if got := opc.Stats(); got.Evictions == 0 {
+		t.Errorf("Expected evictions, got: %+v", got)
+	}
+
+	opc.Clear()
+	if got := opc.weight.Load(); got != 0 {
+		t.Errorf("Expected weight %d, got: %d", 0, got)
+	}
+}
+
+func TestOpCacheMaxWeightRestore(t *testing.T) {
+	cfg := OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+	}
+	opc := NewOpCache[int, string](cfg)
+	for i := range 5 {
+		opc.Set(i, "1234567890", nil)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := opc.Snapshot(buf, GobCodec); err != nil {
+		t.Fatalf("Failed to snapshot: %v", err)
+	}
+
+	// Restored entries must be weighed:
+	cfg.MaxWeight = 20
+	opc2 := NewOpCache[int, string](cfg).WithWeigher(func(key int, result string) int64 { return int64(len(result)) })
+	if _, err := opc2.Restore(buf, GobCodec); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if got := opc2.Stats(); got.Weight != 20 || got.Entries != 2 {
+		t.Errorf("Expected weight %d, %d entries, got: %+v", 20, 2, got)
+	}
+}
+
+func TestOpCacheRefreshAhead(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var loads, loaderLoads atomic.Int64
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		Clock:                  fc,
+		RefreshAhead:           10 * time.Second,
+		RefreshConcurrency:     2,
+		RefreshIdlePeriod:      90 * time.Second,
+	}).WithLoader(func(ctx context.Context, key int) (int, error) {
+		return key*100 + int(loaderLoads.Add(1)), nil
+	})
+	defer opc.Close()
+
+	execOp := func() (int, error) { return 10 + int(loads.Add(1)), nil } // Per-key loader
+	opc.Get(1, execOp)                                                   // 11
+	opc.Set(2, 2, nil)                                                   // Never read, must not be refreshed
+	opc.MultiGet([]int{3}, func(keyIndices []int) ([]int, []error) {     // Refreshed by Loader
+		return []int{3}, make([]error, 1)
+	})
+
+	waitResult := func(key, result int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			entry, _ := opc.Peek(key)
+			if entry.Result == result {
+				if exp := fc.Now().Add(time.Minute); !entry.ExpiresAt.Equal(exp) {
+					t.Errorf("Expected expiration %v, got: %v", exp, entry.ExpiresAt)
+				}
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected result %d for key %d, got: %d", result, key, entry.Result)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	fc.Advance(55 * time.Second)
+	waitResult(1, 12)
+	waitResult(3, 301)
+	if entry, _ := opc.Peek(2); entry.Result != 2 {
+		t.Errorf("Expected %d, got: %d", 2, entry.Result)
+	}
+
+	opc.Get(1, execOp) // Valid hit, 1 is not idle
+
+	// Only entries read within the idle period are refreshed:
+	fc.Advance(55 * time.Second)
+	waitResult(1, 13)
+	opc.refresh(context.Background())
+	if entry, _ := opc.Peek(3); entry.Result != 301 {
+		t.Errorf("Expected %d, got: %d", 301, entry.Result)
+	}
+	if got := opc.Stats().Reloads; got != 3 {
+		t.Errorf("Expected %d reloads, got: %d", 3, got)
+	}
+}
+
+func TestOpCacheRefreshAheadClose(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var reloadErrs atomic.Int64
+	started := make(chan struct{})
+	opc := NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:       time.Minute,
+		AutoEvictPeriodMinutes: -1,
+		Clock:                  fc,
+		RefreshAhead:           10 * time.Second,
+		OnReloadError:          func(key any, err error) { reloadErrs.Add(1) },
+	})
+
+	var loads atomic.Int64
+	opc.GetCtx(context.Background(), 1, func(ctx context.Context) (int, error) {
+		if loads.Add(1) == 1 {
+			return 1, nil
+		}
+		// Refresh blocks until it's cancelled by Close:
+		close(started)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+
+	fc.Advance(55 * time.Second)
+	<-started
+	opc.Close()
+
+	// Wait for the refresh to end:
+	for len(opc.refreshSem) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := opc.Len(); n != 0 {
+		t.Errorf("Expected %d entries, got: %d", 0, n)
+	}
+	if n := reloadErrs.Load(); n != 0 {
+		t.Errorf("Expected %d reload errors, got: %d", 0, n)
+	}
+}
+
+func TestOpCacheHooks(t *testing.T) {
+	fc := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	errTest := errors.New("test")
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(format string, args ...any) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	reloaded := make(chan struct{})
+
+	var opc *OpCache[int, int]
+	opc = NewOpCache[int, int](OpCacheConfig{
+		ResultExpiration:        time.Minute,
+		ResultGraceExpiration:   time.Minute,
+		KeepResultOnReloadError: true,
+		AutoEvictPeriodMinutes:  -1,
+		MaxEntries:              2,
+		Clock:                   fc,
+		OnLoad:                  func(key any, d time.Duration, err error) { record("load %v %v", key, err) },
+		OnHit:                   func(key any) { record("hit %v", key) },
+		OnGraceHit:              func(key any) { record("grace hit %v", key) },
+		OnEvict: func(key any, reason EvictionReason) {
+			record("evict %v %v", key, reason)
+			opc.Len() // Hooks may use the cache
+		},
+		OnReloadError: func(key any, err error) {
+			record("reload error %v %v", key, err)
+			close(reloaded)
+		},
+	})
+
+	opc.Get(1, func() (int, error) { return 1, nil })
+	opc.Get(1, func() (int, error) { return 1, nil })
+	opc.MultiGet([]int{2, 3}, func(keyIndices []int) ([]int, []error) { return []int{2, 3}, make([]error, 2) })
+
+	fc.Advance(90 * time.Second)
+	opc.Get(2, func() (int, error) { return 0, errTest })
+	<-reloaded
+
+	opc.Remove(2, 4)
+	fc.Advance(time.Minute)
+	opc.Evict()
+	opc.Set(5, 5, nil)
+	opc.Clear()
+
+	exp := []string{
+		"load 1 <nil>",
+		"hit 1",
+		"load 2 <nil>",
+		"load 3 <nil>",
+		"evict 1 capacity",
+		"grace hit 2",
+		"load 2 test",
+		"reload error 2 test",
+		"evict 2 removed",
+		"evict 3 expired",
+		"evict 5 cleared",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(events, exp) {
+		t.Errorf("Expected %q, got: %q", exp, events)
+	}
+}
@@ -0,0 +1,133 @@
+package gox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrWorkerPoolAborting is returned by [CtxWorkerPool.Enqueue] if the pool is aborting
+// (a job handler returned an error).
+var ErrWorkerPoolAborting = errors.New("worker pool is aborting")
+
+// CtxWorkerPool is a context-aware variant of [WorkerPool]: job handlers receive a context and may return an error.
+//
+// Like errgroup, the context passed to job handlers is cancelled when the first job handler returns an error
+// (or when the context passed to [CtxWorkerPool.Start] is cancelled), after which [CtxWorkerPool.Enqueue]
+// returns an error instead of blocking.
+//
+// [CtxWorkerPool.Start] must be called before enqueuing jobs, and [CtxWorkerPool.Wait] must be called
+// after [CtxWorkerPool.Start] to wait for all enqueued jobs to be processed.
+// Jobs can only be enqueued between the [CtxWorkerPool.Start] and [CtxWorkerPool.Wait] calls, using the [CtxWorkerPool.Enqueue] method.
+//
+// The worker pool is re-startable: after [CtxWorkerPool.Wait] returns, [CtxWorkerPool.Start] can be called again to start a new round of job processing.
+//
+// NOTE: this CtxWorkerPool API is not final, may change in the future.
+type CtxWorkerPool[Job any] struct {
+	// Number of workers to process jobs concurrently.
+	// Defaults to 1 if not set.
+	WorkersCount int
+
+	// HandleJob is a function responsible to handle (process) a single job.
+	// A non-nil error aborts the pool: the context of job handlers is cancelled, and no more jobs can be enqueued.
+	HandleJob func(ctx context.Context, job Job) error
+
+	// JoinErrors tells [CtxWorkerPool.Wait] to return all errors returned by job handlers joined (see [errors.Join]),
+	// instead of only the first one.
+	// Note that job handlers that are already running (or jobs already accepted) when the pool aborts
+	// may still return errors.
+	JoinErrors bool
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	workersWg *sync.WaitGroup
+	jobsCh    chan Job
+
+	errsMu sync.Mutex
+	errs   []error
+}
+
+// Start launches internal goroutines of the worker pool. Returns immediately.
+// Job handlers receive a context derived from ctx.
+func (wp *CtxWorkerPool[Job]) Start(ctx context.Context) {
+	// Reset state, initialize internal channels and wait groups:
+	wp.ctx, wp.cancel = context.WithCancelCause(ctx)
+	wp.errs = nil
+	wp.workersWg = &sync.WaitGroup{}
+	wp.jobsCh = make(chan Job)
+
+	// Launch a pool of workers for concurrent processing:
+	for range ForceMin(wp.WorkersCount, 1) {
+		wp.workersWg.Go(func() {
+			for job := range wp.jobsCh {
+				if err := wp.HandleJob(wp.ctx, job); err != nil {
+					wp.abort(err)
+				}
+			}
+		})
+	}
+}
+
+// abort records the error of a job handler, and cancels the pool's context.
+func (wp *CtxWorkerPool[Job]) abort(err error) {
+	wp.errsMu.Lock()
+	defer wp.errsMu.Unlock()
+
+	wp.errs = append(wp.errs, err)
+	wp.cancel(err) // Only the first call sets the cause
+}
+
+// Context returns the context passed to job handlers. It's cancelled when the pool aborts.
+// Only valid after [CtxWorkerPool.Start] is called.
+func (wp *CtxWorkerPool[Job]) Context() context.Context {
+	return wp.ctx
+}
+
+// Enqueue adds a job to the processing queue. It blocks until a worker accepts the job,
+// or the pool aborts (its context is cancelled).
+//
+// If the pool is aborting, an error wrapping ErrWorkerPoolAborting and the cause of the abortion
+// (the first job handler error, or the error of the context passed to [CtxWorkerPool.Start]) is returned.
+func (wp *CtxWorkerPool[Job]) Enqueue(job Job) error {
+	// Check first, so we don't enqueue if a worker is also ready:
+	if wp.ctx.Err() != nil {
+		return wp.abortingErr()
+	}
+
+	select {
+	case wp.jobsCh <- job:
+		return nil
+	case <-wp.ctx.Done():
+		return wp.abortingErr()
+	}
+}
+
+// abortingErr returns the error to report if the pool is aborting.
+func (wp *CtxWorkerPool[Job]) abortingErr() error {
+	return fmt.Errorf("%w: %w", ErrWorkerPoolAborting, context.Cause(wp.ctx))
+}
+
+// Wait signals the end of jobs, and blocks until all enqueued jobs have been processed.
+// Returns the first error returned by a job handler (or all of them joined, see JoinErrors), nil if there was none.
+// Note: a worker pool is re-startable, but [CtxWorkerPool.Wait] must be called and only once after a [CtxWorkerPool.Start] call.
+func (wp *CtxWorkerPool[Job]) Wait() error {
+	// First close the jobs channel (no more jobs can be enqueued)...
+	close(wp.jobsCh)
+	// ... and wait for the workers to end.
+	wp.workersWg.Wait()
+	// Release resources of the context:
+	wp.cancel(nil)
+
+	wp.errsMu.Lock()
+	defer wp.errsMu.Unlock()
+
+	if len(wp.errs) == 0 {
+		return nil
+	}
+	if wp.JoinErrors {
+		return errors.Join(wp.errs...)
+	}
+	return wp.errs[0]
+}
@@ -0,0 +1,360 @@
+package gox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// JobJournal is a persistent, file-backed journal of the jobs of a [WorkerPool], see [WorkerPool.Journal].
+//
+// Enqueued jobs are appended to the journal file (and synced to disk), and they are acknowledged
+// when their handling completes. Jobs not acknowledged (e.g. because the process crashed or was stopped)
+// are replayed when the worker pool using the journal is started the next time.
+// Delivery is at-least-once: a job whose handling completed just before a crash may be replayed.
+//
+// The journal file is append-only while the worker pool is running, it is compacted
+// when the journal is opened, and truncated when the worker pool finishes with no pending jobs.
+type JobJournal[Job any] struct {
+	mu      sync.Mutex
+	path    string
+	codec   Codec
+	f       *os.File
+	nextID  uint64
+	pending map[uint64][]byte // Encoded jobs not yet acknowledged, by ID
+	replay  []uint64          // IDs of jobs to be replayed on the next Start
+}
+
+// Journal record types.
+const (
+	journalRecordAdd byte = 'A'
+	journalRecordAck byte = 'K'
+)
+
+// OpenJobJournal opens the journal of the given file, which is created if it doesn't exist.
+// Jobs are encoded using codec, if nil, [GobCodec] is used. The Job type must be encodable by the codec.
+//
+// Pending jobs of the journal (enqueued but not acknowledged earlier) are replayed by
+// the next [WorkerPool.Start] call of a pool using the journal.
+func OpenJobJournal[Job any](path string, codec Codec) (*JobJournal[Job], error) {
+	if codec == nil {
+		codec = GobCodec
+	}
+	j := &JobJournal[Job]{
+		path:    path,
+		codec:   codec,
+		nextID:  1,
+		pending: map[uint64][]byte{},
+	}
+
+	if err := j.load(); err != nil {
+		return nil, fmt.Errorf("failed to load journal: %w", err)
+	}
+	// Validate pending jobs now, rather than failing on replay:
+	for _, id := range j.replay {
+		if _, err := j.decode(j.pending[id]); err != nil {
+			return nil, fmt.Errorf("failed to decode journaled job: %w", err)
+		}
+	}
+	if err := j.compact(); err != nil {
+		return nil, fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	return j, nil
+}
+
+// load reads the journal file, and collects jobs that are not acknowledged.
+func (j *JobJournal[Job]) load() error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		typ, id, data, err := readJournalRecord(r)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// End of journal. A partial record is the result of an interrupted write, it's ignored.
+				break
+			}
+			return err
+		}
+
+		j.nextID = max(j.nextID, id+1)
+		switch typ {
+		case journalRecordAdd:
+			j.pending[id] = data
+		case journalRecordAck:
+			delete(j.pending, id)
+		}
+	}
+
+	for id := range j.pending {
+		j.replay = append(j.replay, id)
+	}
+	slices.Sort(j.replay) // Replay in enqueue order
+
+	return nil
+}
+
+// readJournalRecord reads a record from r.
+// io.EOF is returned if there are no more records, io.ErrUnexpectedEOF if the last record is partial.
+func readJournalRecord(r *bufio.Reader) (typ byte, id uint64, data []byte, err error) {
+	if typ, err = r.ReadByte(); err != nil {
+		return
+	}
+	if typ != journalRecordAdd && typ != journalRecordAck {
+		return 0, 0, nil, fmt.Errorf("invalid journal record type: %d", typ)
+	}
+
+	if id, err = binary.ReadUvarint(r); err != nil {
+		return 0, 0, nil, unexpectedEOF(err)
+	}
+	if typ == journalRecordAck {
+		return
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, nil, unexpectedEOF(err)
+	}
+	data = make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return 0, 0, nil, unexpectedEOF(err)
+	}
+	return
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF if err is io.EOF, else err.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendJournalRecord appends a record to buf.
+func appendJournalRecord(buf []byte, typ byte, id uint64, data []byte) []byte {
+	buf = append(buf, typ)
+	buf = binary.AppendUvarint(buf, id)
+	if typ == journalRecordAdd {
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf
+}
+
+// compact rewrites the journal file to only contain the pending jobs, and opens it for appending.
+// The file is replaced atomically (written to a temp file which is renamed).
+func (j *JobJournal[Job]) compact() error {
+	if j.f != nil {
+		if err := j.f.Close(); err != nil {
+			return err
+		}
+		j.f = nil
+	}
+
+	var buf []byte
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		buf = appendJournalRecord(buf, journalRecordAdd, id, j.pending[id])
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = os.Rename(f.Name(), j.path); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	j.f = f // Offset is at the end, ready for appending
+	return nil
+}
+
+// decode decodes a job.
+func (j *JobJournal[Job]) decode(data []byte) (job Job, err error) {
+	err = j.codec.NewDecoder(bytes.NewReader(data)).Decode(&job)
+	return
+}
+
+// add journals the given job, and returns its ID.
+func (j *JobJournal[Job]) add(job Job) (id uint64, err error) {
+	var buf bytes.Buffer
+	if err = j.codec.NewEncoder(&buf).Encode(job); err != nil {
+		return 0, fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return 0, errors.New("journal is closed")
+	}
+
+	id = j.nextID
+	if _, err = j.f.Write(appendJournalRecord(nil, journalRecordAdd, id, buf.Bytes())); err != nil {
+		return 0, err
+	}
+	if err = j.f.Sync(); err != nil {
+		return 0, err
+	}
+
+	j.nextID++
+	j.pending[id] = buf.Bytes()
+	return id, nil
+}
+
+// ack acknowledges the job of the given ID.
+// Acks are not synced to disk: losing an ack only results in replaying the job.
+func (j *JobJournal[Job]) ack(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return errors.New("journal is closed")
+	}
+
+	delete(j.pending, id)
+	_, err := j.f.Write(appendJournalRecord(nil, journalRecordAck, id, nil))
+	return err
+}
+
+// takeReplay returns the pending jobs to be replayed, and clears them so they are replayed only once.
+func (j *JobJournal[Job]) takeReplay() (jobs []queuedJob[Job], err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, id := range j.replay {
+		job, err := j.decode(j.pending[id])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode journaled job: %w", err)
+		}
+		jobs = append(jobs, queuedJob[Job]{job: job, id: id})
+	}
+	j.replay = nil
+	return
+}
+
+// finish truncates the journal file if there are no pending jobs.
+func (j *JobJournal[Job]) finish() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil || len(j.pending) > 0 {
+		return nil
+	}
+	if err := j.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := j.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Pending returns the number of jobs that are enqueued (or to be replayed) but not yet acknowledged.
+func (j *JobJournal[Job]) Pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return len(j.pending)
+}
+
+// Close closes the journal file. Pending jobs remain in the file to be replayed when it is opened again.
+// Must not be called while a worker pool using the journal is running.
+func (j *JobJournal[Job]) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// queuedJob is a job in the queue of a WorkerPool.
+type queuedJob[Job any] struct {
+	job Job
+	id  uint64 // ID of the job in the journal, 0 if not journaled
+
+	// journaled receives the ID of the job if it's journaled after it was enqueued (see WorkerPool.TryEnqueue),
+	// nil otherwise.
+	journaled chan uint64
+}
+
+// journalAdd journals the given job if the pool has a journal.
+// Journaling errors are reported to OnJournalError, and the job is still processed (without being journaled).
+func (wp *WorkerPool[Job]) journalAdd(job Job) queuedJob[Job] {
+	qj := queuedJob[Job]{job: job}
+	if wp.Journal == nil {
+		return qj
+	}
+
+	id, err := wp.Journal.add(job)
+	if err != nil {
+		wp.journalError(fmt.Errorf("failed to journal job: %w", err))
+		return qj
+	}
+	qj.id = id
+	return qj
+}
+
+// journalAck acknowledges the given job if it was journaled.
+// If the job is journaled after it was enqueued, journalAck waits for its journal ID.
+func (wp *WorkerPool[Job]) journalAck(qj queuedJob[Job]) {
+	if qj.journaled != nil {
+		qj.id = <-qj.journaled
+	}
+	if qj.id == 0 {
+		return
+	}
+	if err := wp.Journal.ack(qj.id); err != nil {
+		wp.journalError(fmt.Errorf("failed to acknowledge job: %w", err))
+	}
+}
+
+// journalError reports a journal error to OnJournalError if provided.
+func (wp *WorkerPool[Job]) journalError(err error) {
+	if wp.OnJournalError != nil {
+		wp.OnJournalError(err)
+	}
+}
+
+// replay enqueues the pending jobs of the journal.
+func (wp *WorkerPool[Job]) replay() {
+	jobs, err := wp.Journal.takeReplay()
+	if err != nil {
+		wp.journalError(err)
+		return
+	}
+	for _, qj := range jobs {
+		wp.jobsCh <- qj
+	}
+}
@@ -0,0 +1,134 @@
+package gox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  float64 // Max tokens
+	tokens float64 // Available tokens at time last, negative if tokens are reserved in advance
+	last   time.Time
+}
+
+// newTokenBucket creates a new, full tokenBucket.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, and returns how long the caller must wait before using it.
+func (tb *tokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.tokens = min(tb.tokens+now.Sub(tb.last).Seconds()*tb.rate, tb.burst)
+	tb.last = now
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// jobKeyState is the state of a job key having running jobs, see WorkerPool.JobKey.
+type jobKeyState[Job any] struct {
+	running int              // Number of running jobs of the key
+	waiting []queuedJob[Job] // Jobs waiting for a running job of the key to finish
+}
+
+// process handles the given job respecting the per-key concurrency limit:
+// if the job's key is saturated, the job is put aside to be handled when a running job of the same key finishes
+// (by the worker of that job), so the worker is free to take the next job.
+func (wp *WorkerPool[Job]) process(qj queuedJob[Job]) {
+	if wp.JobKey == nil || wp.MaxPerKey <= 0 {
+		wp.runJob(qj)
+		return
+	}
+
+	key := wp.JobKey(qj.job)
+
+	wp.keysMu.Lock()
+	ks := wp.keys[key]
+	if ks == nil {
+		ks = &jobKeyState[Job]{}
+		wp.keys[key] = ks
+	}
+	if ks.running >= wp.MaxPerKey {
+		ks.waiting = append(ks.waiting, qj)
+		wp.parked++
+		wp.keysMu.Unlock()
+		return
+	}
+	ks.running++
+	wp.keysMu.Unlock()
+
+	for {
+		wp.runJob(qj)
+
+		// Continue with a waiting job of the same key if there is one:
+		wp.keysMu.Lock()
+		if len(ks.waiting) == 0 {
+			ks.running--
+			if ks.running == 0 {
+				delete(wp.keys, key)
+			}
+			wp.keysMu.Unlock()
+			return
+		}
+		qj = ks.waiting[0]
+		ks.waiting[0] = queuedJob[Job]{} // Don't retain the job
+		ks.waiting = ks.waiting[1:]
+		wp.parked--
+		if wp.parkedFreed != nil {
+			close(wp.parkedFreed)
+			wp.parkedFreed = nil
+		}
+		wp.keysMu.Unlock()
+	}
+}
+
+// parkRoom returns nil if a job may be enqueued without exceeding MaxWaiting,
+// else a channel that is closed when the number of jobs waiting for their key decreases.
+func (wp *WorkerPool[Job]) parkRoom() <-chan struct{} {
+	if wp.MaxWaiting <= 0 || wp.JobKey == nil || wp.MaxPerKey <= 0 {
+		return nil
+	}
+
+	wp.keysMu.Lock()
+	defer wp.keysMu.Unlock()
+
+	if wp.parked < wp.MaxWaiting {
+		return nil
+	}
+	if wp.parkedFreed == nil {
+		wp.parkedFreed = make(chan struct{})
+	}
+	return wp.parkedFreed
+}
+
+// waitParkRoom waits until a job may be enqueued without exceeding MaxWaiting.
+// If ctx is cancelled before that, ctx.Err() is returned.
+func (wp *WorkerPool[Job]) waitParkRoom(ctx context.Context) error {
+	for {
+		room := wp.parkRoom()
+		if room == nil {
+			return nil
+		}
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package gox
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PriorityWorkerPool is a variant of [WorkerPool] that dispatches the highest-priority pending job next
+// (instead of processing jobs in enqueue order). Jobs of equal priority are processed in enqueue order.
+//
+// The queue of pending jobs is unbounded, enqueuing never blocks.
+//
+// Optionally jobs may age (see AgingPeriod), so low-priority jobs do not starve.
+//
+// [PriorityWorkerPool.Start] must be called before enqueuing jobs, and [PriorityWorkerPool.Wait] must be called
+// after [PriorityWorkerPool.Start] to wait for all enqueued jobs to be processed.
+// Jobs can only be enqueued between the [PriorityWorkerPool.Start] and [PriorityWorkerPool.Wait] calls.
+//
+// The worker pool is re-startable: after [PriorityWorkerPool.Wait] returns, [PriorityWorkerPool.Start] can be called again to start a new round of job processing.
+//
+// NOTE: this PriorityWorkerPool API is not final, may change in the future.
+type PriorityWorkerPool[Job any] struct {
+	// Number of workers to process jobs concurrently.
+	// Defaults to 1 if not set.
+	WorkersCount int
+
+	// HandleJob is a function responsible to handle (process) a single job.
+	// true return value may be used to request abortion.
+	// The caller may choose to stop enqueuing new jobs when [PriorityWorkerPool.AbortRequested] returns true.
+	HandleJob func(job Job) (requestAbort bool)
+
+	// Priority is an optional function to tell the priority of a job enqueued with [PriorityWorkerPool.Enqueue].
+	// Higher value means higher priority. If not provided, jobs have 0 priority.
+	Priority func(job Job) int
+
+	// AgingPeriod is the time after which the priority of a pending job is increased by 1
+	// (so a job pending for 3 aging periods outranks a job with 2 higher priority that was just enqueued).
+	// If 0, jobs do not age.
+	AgingPeriod time.Duration
+
+	workersWg *sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   priorityQueue[Job]
+	closed  bool // Tells if Wait has been called (no more jobs)
+	start   time.Time
+	nextSeq int64
+
+	abortRequested atomic.Bool
+}
+
+// priorityItem is a pending job of a PriorityWorkerPool.
+type priorityItem[Job any] struct {
+	job   Job
+	score float64 // Higher score is dispatched first
+	seq   int64   // Enqueue order, for FIFO order of equal scores
+}
+
+// priorityQueue implements heap.Interface, the item with the highest score is at the root.
+type priorityQueue[Job any] []priorityItem[Job]
+
+func (pq priorityQueue[Job]) Len() int { return len(pq) }
+func (pq priorityQueue[Job]) Less(i, j int) bool {
+	if pq[i].score != pq[j].score {
+		return pq[i].score > pq[j].score
+	}
+	return pq[i].seq < pq[j].seq
+}
+func (pq priorityQueue[Job]) Swap(i, j int) { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *priorityQueue[Job]) Push(x any)   { *pq = append(*pq, x.(priorityItem[Job])) }
+func (pq *priorityQueue[Job]) Pop() any {
+	old := *pq
+	item := old[len(old)-1]
+	old[len(old)-1] = priorityItem[Job]{} // Don't retain the job
+	*pq = old[:len(old)-1]
+	return item
+}
+
+// Start launches internal goroutines of the worker pool. Returns immediately.
+func (wp *PriorityWorkerPool[Job]) Start() {
+	// Reset state:
+	wp.abortRequested.Store(false)
+	wp.workersWg = &sync.WaitGroup{}
+	wp.cond = sync.NewCond(&wp.mu)
+	wp.queue = nil
+	wp.closed = false
+	wp.start = time.Now()
+	wp.nextSeq = 0
+
+	// Launch a pool of workers for concurrent processing:
+	for range ForceMin(wp.WorkersCount, 1) {
+		wp.workersWg.Go(func() {
+			for {
+				job, ok := wp.next()
+				if !ok {
+					return
+				}
+				if wp.HandleJob(job) {
+					wp.abortRequested.Store(true)
+				}
+			}
+		})
+	}
+}
+
+// next waits for and returns the next job to process. ok is false if there are no more jobs.
+func (wp *PriorityWorkerPool[Job]) next() (job Job, ok bool) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	for len(wp.queue) == 0 {
+		if wp.closed {
+			return job, false
+		}
+		wp.cond.Wait()
+	}
+	return heap.Pop(&wp.queue).(priorityItem[Job]).job, true
+}
+
+// AbortRequested reports if a job handler has requested abortion.
+func (wp *PriorityWorkerPool[Job]) AbortRequested() bool {
+	return wp.abortRequested.Load()
+}
+
+// Enqueue adds a job to the processing queue, with priority told by the Priority function.
+// Panics if called after [PriorityWorkerPool.Wait] (like [WorkerPool.Enqueue] does).
+func (wp *PriorityWorkerPool[Job]) Enqueue(job Job) {
+	var priority int
+	if wp.Priority != nil {
+		priority = wp.Priority(job)
+	}
+	wp.EnqueuePriority(job, priority)
+}
+
+// EnqueuePriority adds a job to the processing queue with the given priority. Higher value means higher priority.
+// Panics if called after [PriorityWorkerPool.Wait] (like [WorkerPool.Enqueue] does), as the job would never be processed.
+func (wp *PriorityWorkerPool[Job]) EnqueuePriority(job Job, priority int) {
+	score := float64(priority)
+	if wp.AgingPeriod > 0 {
+		// All pending jobs age at the same rate, so instead of increasing the priority of pending jobs,
+		// the priority of later enqueued jobs is decreased (which keeps the order of pending jobs fixed):
+		score -= float64(time.Since(wp.start)) / float64(wp.AgingPeriod)
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.closed {
+		panic("enqueue on a waited PriorityWorkerPool")
+	}
+	heap.Push(&wp.queue, priorityItem[Job]{job: job, score: score, seq: wp.nextSeq})
+	wp.nextSeq++
+	wp.cond.Signal()
+}
+
+// QueueLen returns the number of jobs waiting in the queue for a free worker.
+func (wp *PriorityWorkerPool[Job]) QueueLen() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	return len(wp.queue)
+}
+
+// Wait signals the end of jobs, and blocks until all enqueued jobs have been processed.
+// Note: a worker pool is re-startable, but [PriorityWorkerPool.Wait] must be called and only once after a [PriorityWorkerPool.Start] call.
+func (wp *PriorityWorkerPool[Job]) Wait() {
+	// Signal the end of jobs (workers exit once the queue is empty)...
+	wp.mu.Lock()
+	wp.closed = true
+	wp.cond.Broadcast()
+	wp.mu.Unlock()
+	// ... and wait for the workers to end.
+	wp.workersWg.Wait()
+}
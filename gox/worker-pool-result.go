@@ -0,0 +1,146 @@
+package gox
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// ResultWorkerPool is a variant of [WorkerPool] whose job handlers produce results,
+// which are delivered on a channel (see [ResultWorkerPool.Results]) or as an iterator (see [ResultWorkerPool.All]).
+//
+// [ResultWorkerPool.Start] must be called before enqueuing jobs, and [ResultWorkerPool.Wait] must be called
+// after [ResultWorkerPool.Start] to wait for all enqueued jobs to be processed.
+// Jobs can only be enqueued between the [ResultWorkerPool.Start] and [ResultWorkerPool.Wait] calls, using the [ResultWorkerPool.Enqueue] method.
+//
+// Results must be consumed concurrently to enqueuing jobs (workers block until their results are received),
+// so jobs are typically enqueued in a separate goroutine:
+//
+//	wp.Start()
+//	go func() {
+//		for _, job := range jobs {
+//			wp.Enqueue(job)
+//		}
+//		wp.Wait()
+//	}()
+//	for result := range wp.All() {
+//		// Use result
+//	}
+//
+// The worker pool is re-startable: after [ResultWorkerPool.Wait] returns, [ResultWorkerPool.Start] can be called again to start a new round of job processing.
+//
+// NOTE: this ResultWorkerPool API is not final, may change in the future.
+type ResultWorkerPool[Job, Result any] struct {
+	// Number of workers to process jobs concurrently.
+	// Defaults to 1 if not set.
+	WorkersCount int
+
+	// HandleJob is a function responsible to handle (process) a single job and produce its result.
+	HandleJob func(job Job) Result
+
+	// Ordered tells to deliver results in the order their jobs were enqueued (even though jobs may finish out of order).
+	// Note that results of jobs finished early are held back until results of all jobs enqueued before them are delivered.
+	Ordered bool
+
+	workersWg   *sync.WaitGroup
+	collectorWg *sync.WaitGroup
+	jobsCh      chan seqItem[Job]
+	doneCh      chan seqItem[Result]
+	resultsCh   chan Result
+
+	nextSeq atomic.Int64
+}
+
+// seqItem is an item with a sequence number.
+type seqItem[T any] struct {
+	seq   int64
+	value T
+}
+
+// Start launches internal goroutines of the worker pool. Returns immediately.
+func (wp *ResultWorkerPool[Job, Result]) Start() {
+	// Reset state, initialize internal channels and wait groups:
+	wp.nextSeq.Store(0)
+	wp.workersWg = &sync.WaitGroup{}
+	wp.collectorWg = &sync.WaitGroup{}
+	wp.jobsCh = make(chan seqItem[Job])
+	wp.doneCh = make(chan seqItem[Result])
+	wp.resultsCh = make(chan Result)
+
+	// Launch a pool of workers for concurrent processing:
+	for range ForceMin(wp.WorkersCount, 1) {
+		wp.workersWg.Go(func() {
+			for job := range wp.jobsCh {
+				wp.doneCh <- seqItem[Result]{job.seq, wp.HandleJob(job.value)}
+			}
+		})
+	}
+
+	// Collect results (and order them if needed):
+	wp.collectorWg.Go(func() {
+		defer close(wp.resultsCh)
+
+		pending := map[int64]Result{}
+		var next int64
+		for done := range wp.doneCh {
+			if !wp.Ordered {
+				wp.resultsCh <- done.value
+				continue
+			}
+			pending[done.seq] = done.value
+			for {
+				result, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				wp.resultsCh <- result
+				next++
+			}
+		}
+	})
+}
+
+// Enqueue adds a job to the processing queue.
+func (wp *ResultWorkerPool[Job, Result]) Enqueue(job Job) {
+	wp.jobsCh <- seqItem[Job]{wp.nextSeq.Add(1) - 1, job}
+}
+
+// Results returns the channel on which results are delivered.
+// The channel is closed when all results have been delivered after [ResultWorkerPool.Wait] is called.
+// Only valid after [ResultWorkerPool.Start] is called.
+func (wp *ResultWorkerPool[Job, Result]) Results() <-chan Result {
+	return wp.resultsCh
+}
+
+// All returns an iterator over the results. See [ResultWorkerPool.Results] for details.
+// Only valid after [ResultWorkerPool.Start] is called.
+//
+// If iteration is stopped early, remaining results are drained (discarded) so the pool is not blocked.
+func (wp *ResultWorkerPool[Job, Result]) All() iter.Seq[Result] {
+	resultsCh := wp.resultsCh
+	return func(yield func(Result) bool) {
+		for result := range resultsCh {
+			if !yield(result) {
+				go func() {
+					for range resultsCh {
+					}
+				}()
+				return
+			}
+		}
+	}
+}
+
+// Wait signals the end of jobs, and blocks until all enqueued jobs have been processed
+// and their results have been received.
+// Note: a worker pool is re-startable, but [ResultWorkerPool.Wait] must be called and only once after a [ResultWorkerPool.Start] call.
+func (wp *ResultWorkerPool[Job, Result]) Wait() {
+	// First close the jobs channel (no more jobs can be enqueued)...
+	close(wp.jobsCh)
+	// ... and wait for the workers to end.
+	wp.workersWg.Wait()
+	// Now we can close the done channel, which will end the result collector goroutine (once all results are delivered).
+	close(wp.doneCh)
+	wp.collectorWg.Wait()
+}
@@ -1,8 +1,11 @@
 package gox
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // WorkerPool is a simple worker pool implementation that processes jobs concurrently,
@@ -18,6 +21,7 @@ import (
 type WorkerPool[Job any] struct {
 	// Number of workers to process jobs concurrently.
 	// Defaults to 1 if not set.
+	// The number of workers of a running pool can be changed using [WorkerPool.SetWorkers].
 	WorkersCount int
 
 	// HandleJob is a function responsible to handle (process) a single job.
@@ -25,9 +29,89 @@ type WorkerPool[Job any] struct {
 	// The caller may choose to stop enqueuing new jobs when [WorkerPool.AbortRequested] returns true.
 	HandleJob func(job Job) (requestAbort bool)
 
-	workersWg *sync.WaitGroup
-	jobsCh    chan Job
-	resultsCh chan bool
+	// QueueCapacity is the number of jobs that may be queued (waiting for a free worker).
+	// If 0, the queue is unbuffered: enqueuing blocks until a worker accepts the job.
+	// See [WorkerPool.TryEnqueue] and [WorkerPool.EnqueueCtx] for non-blocking and cancellable enqueuing.
+	QueueCapacity int
+
+	// PanicPolicy tells how panics of HandleJob are handled.
+	// Defaults to PanicPropagate (panics are not recovered and crash the process).
+	PanicPolicy PanicPolicy
+
+	// OnPanic is an optional function called with the job whose handling panicked,
+	// and the recovered panic (including the stack trace).
+	// Only called if panics are recovered (PanicPolicy is not PanicPropagate).
+	OnPanic func(job Job, err *PanicError)
+
+	// Autoscale is an optional function called after each handled job with a sample of the pool's state
+	// (including the latency of the handled job). If it returns a positive number that differs from
+	// the current number of workers, the pool is resized to it (see [WorkerPool.SetWorkers]).
+	//
+	// Autoscale is called from the workers, so it may be called concurrently and it should return quickly.
+	Autoscale func(sample WorkerPoolSample) (workers int)
+
+	// Rate is the maximum number of jobs to start per second (on average), enforced by a token bucket.
+	// If 0, the rate is not limited.
+	Rate float64
+
+	// Burst is the maximum number of jobs that may be started at once (the size of the token bucket).
+	// Defaults to 1 if not set. Only used if Rate > 0.
+	Burst int
+
+	// JobKey is an optional function to tell the key of a job (e.g. its tenant), used to limit
+	// the number of concurrently handled jobs having the same key (see MaxPerKey).
+	// Keys must be comparable.
+	JobKey func(job Job) any
+
+	// MaxPerKey is the maximum number of concurrently handled jobs having the same key (see JobKey).
+	// Jobs of a saturated key wait (without occupying a worker) until a job of the same key finishes,
+	// so other keys are not blocked. Waiting jobs are reported by [WorkerPool.QueueLen].
+	// If 0, concurrency is not limited per key.
+	MaxPerKey int
+
+	// MaxWaiting is the maximum number of jobs waiting for their key (see MaxPerKey).
+	// If that many jobs are waiting, enqueuing blocks (or fails, see [WorkerPool.TryEnqueue]) until one of them is started.
+	// Jobs already in the queue at that time may still be put aside to wait, so the limit may be exceeded
+	// by up to QueueCapacity plus the number of workers. If 0, the number of waiting jobs is not limited.
+	MaxWaiting int
+
+	// Journal is an optional persistent journal of jobs (see [OpenJobJournal]).
+	// If provided, enqueued jobs are journaled, and they are acknowledged after their handling completes.
+	// Jobs not acknowledged when the process stopped are replayed (enqueued again) by [WorkerPool.Start].
+	// A journal must not be used by multiple pools at the same time.
+	//
+	// Jobs are journaled before they are enqueued, except by [WorkerPool.TryEnqueue] which journals jobs
+	// after they are enqueued, so rejected jobs are never journaled. [WorkerPool.EnqueueCtx] doesn't journal
+	// a job if ctx is already done, but a job journaled and then rejected because ctx got cancelled meanwhile
+	// costs a synced journal write and an acknowledgement.
+	Journal *JobJournal[Job]
+
+	// OnJournalError is an optional function called with errors of the Journal.
+	// Jobs that fail to be journaled are still processed (but they are not replayed after a crash).
+	OnJournalError func(err error)
+
+	limiter *tokenBucket
+
+	keysMu      sync.Mutex
+	keys        map[any]*jobKeyState[Job] // States of keys having running jobs, see JobKey
+	parked      int                       // Number of jobs waiting for their key, guarded by keysMu
+	parkedFreed chan struct{}             // Closed when parked decreases, created on demand, see MaxWaiting
+
+	replayWg *sync.WaitGroup // Waits for replaying journaled jobs, see Journal
+
+	activeWorkers atomic.Int64
+
+	workersMu   sync.Mutex
+	workerQuits []chan struct{} // Quit channels of running workers, closing one stops its worker
+	waiting     bool            // Tells if Wait has been called (no more workers can be added)
+
+	panicMu    sync.Mutex
+	firstPanic *PanicError // First recovered panic to re-panic with, see PanicRepanic
+
+	workersWg      *sync.WaitGroup
+	handleResultWg *sync.WaitGroup
+	jobsCh         chan queuedJob[Job]
+	resultsCh      chan bool
 
 	abortRequested atomic.Bool
 }
@@ -36,28 +120,172 @@ type WorkerPool[Job any] struct {
 func (wp *WorkerPool[Job]) Start() {
 	// Reset state, initialize internal channels and wait groups:
 	wp.abortRequested.Store(false)
+	wp.firstPanic = nil
 	wp.workersWg = &sync.WaitGroup{}
-	wp.jobsCh = make(chan Job)
+	wp.jobsCh = make(chan queuedJob[Job], ForceMin(wp.QueueCapacity, 0))
 	wp.resultsCh = make(chan bool)
+	wp.workerQuits = nil
+	wp.waiting = false
+	wp.limiter = nil
+	if wp.Rate > 0 {
+		wp.limiter = newTokenBucket(wp.Rate, ForceMin(wp.Burst, 1))
+	}
+	wp.keys = map[any]*jobKeyState[Job]{}
+	wp.parked = 0
+	wp.parkedFreed = nil
 
 	// Launch a pool of workers for concurrent processing:
-	for range ForceMin(wp.WorkersCount, 1) {
-		wp.workersWg.Go(func() {
-			for job := range wp.jobsCh {
-				wp.resultsCh <- wp.HandleJob(job)
-			}
-		})
-	}
+	wp.SetWorkers(wp.WorkersCount)
 
 	// Gather results
-	handleResultWg := &sync.WaitGroup{}
-	handleResultWg.Go(func() {
+	wp.handleResultWg = &sync.WaitGroup{}
+	wp.handleResultWg.Go(func() {
 		for result := range wp.resultsCh {
 			if result {
 				wp.abortRequested.Store(true)
 			}
 		}
 	})
+
+	// Replay pending jobs of the journal (in the background, as enqueuing may block):
+	wp.replayWg = &sync.WaitGroup{}
+	if wp.Journal != nil {
+		wp.replayWg.Go(wp.replay)
+	}
+}
+
+// SetWorkers sets the number of workers of the running pool (minimum 1).
+// New workers are launched if n is greater than the current number, or workers are stopped if it's less
+// (busy workers are stopped after finishing their current job). No enqueued jobs are lost.
+//
+// Must be called between the [WorkerPool.Start] and [WorkerPool.Wait] calls (it's a no-op after Wait is called).
+func (wp *WorkerPool[Job]) SetWorkers(n int) {
+	n = ForceMin(n, 1)
+
+	wp.workersMu.Lock()
+	defer wp.workersMu.Unlock()
+
+	if wp.waiting {
+		return
+	}
+
+	for len(wp.workerQuits) < n {
+		quit := make(chan struct{})
+		wp.workerQuits = append(wp.workerQuits, quit)
+		wp.workersWg.Go(func() { wp.runWorker(quit) })
+	}
+	for len(wp.workerQuits) > n {
+		last := len(wp.workerQuits) - 1
+		close(wp.workerQuits[last])
+		wp.workerQuits = wp.workerQuits[:last]
+	}
+}
+
+// Workers returns the number of workers of the running pool.
+func (wp *WorkerPool[Job]) Workers() int {
+	wp.workersMu.Lock()
+	defer wp.workersMu.Unlock()
+
+	return len(wp.workerQuits)
+}
+
+// runWorker handles jobs until the jobs channel is closed or quit is closed.
+func (wp *WorkerPool[Job]) runWorker(quit <-chan struct{}) {
+	for {
+		// Check quit first, so a stopped worker doesn't take another job if jobs are also available:
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		select {
+		case <-quit:
+			return
+		case qj, ok := <-wp.jobsCh:
+			if !ok {
+				return
+			}
+			wp.process(qj)
+		}
+	}
+}
+
+// runJob handles a single job (respecting the rate limit), acknowledges it, and reports its result.
+func (wp *WorkerPool[Job]) runJob(qj queuedJob[Job]) {
+	if wp.limiter != nil {
+		time.Sleep(wp.limiter.reserve())
+	}
+
+	wp.activeWorkers.Add(1)
+	start := time.Now()
+	result := wp.handleJob(qj.job)
+	latency := time.Since(start)
+	wp.activeWorkers.Add(-1)
+	wp.journalAck(qj)
+	wp.resultsCh <- result
+
+	if wp.Autoscale != nil {
+		wp.autoscale(latency)
+	}
+}
+
+// autoscale calls the Autoscale function, and resizes the pool if needed.
+func (wp *WorkerPool[Job]) autoscale(latency time.Duration) {
+	workers := wp.Workers()
+	n := wp.Autoscale(WorkerPoolSample{
+		QueueLen:      wp.QueueLen(),
+		Workers:       workers,
+		ActiveWorkers: wp.ActiveWorkers(),
+		Latency:       latency,
+	})
+	if n > 0 && n != workers {
+		wp.SetWorkers(n)
+	}
+}
+
+// WorkerPoolSample is a sample of the state of a [WorkerPool], see [WorkerPool.Autoscale].
+type WorkerPoolSample struct {
+	// QueueLen is the number of jobs waiting in the queue for a free worker.
+	QueueLen int
+
+	// Workers is the number of workers of the pool.
+	Workers int
+
+	// ActiveWorkers is the number of workers currently handling a job.
+	ActiveWorkers int
+
+	// Latency is the time it took to handle the last job.
+	Latency time.Duration
+}
+
+// handleJob calls HandleJob, and handles its panic according to PanicPolicy.
+func (wp *WorkerPool[Job]) handleJob(job Job) (requestAbort bool) {
+	if wp.PanicPolicy == PanicPropagate {
+		return wp.HandleJob(job)
+	}
+
+	err := ProtectStack(func() { requestAbort = wp.HandleJob(job) })
+	if err == nil {
+		return
+	}
+
+	pe := err.(*PanicError)
+	if wp.OnPanic != nil {
+		wp.OnPanic(job, pe)
+	}
+
+	switch wp.PanicPolicy {
+	case PanicAbort:
+		return true
+	case PanicRepanic:
+		wp.panicMu.Lock()
+		if wp.firstPanic == nil {
+			wp.firstPanic = pe
+		}
+		wp.panicMu.Unlock()
+	}
+	return false
 }
 
 // AbortRequested reports if a job handler has requested abortion.
@@ -66,17 +294,113 @@ func (wp *WorkerPool[Job]) AbortRequested() bool {
 }
 
 // Enqueue adds a job to the processing queue.
+// It blocks until there is room in the queue (or a worker accepts the job if the queue is unbuffered).
 func (wp *WorkerPool[Job]) Enqueue(job Job) {
-	wp.jobsCh <- job
+	wp.waitParkRoom(context.Background())
+	wp.jobsCh <- wp.journalAdd(job)
+}
+
+// TryEnqueue adds a job to the processing queue if it can be done without blocking,
+// that is if there is room in the queue (or a worker is ready to accept the job if the queue is unbuffered).
+// Returns false if the job was not enqueued (also if MaxWaiting jobs are waiting for their key).
+func (wp *WorkerPool[Job]) TryEnqueue(job Job) bool {
+	if wp.parkRoom() != nil {
+		return false
+	}
+
+	qj := queuedJob[Job]{job: job}
+	if wp.Journal != nil {
+		// Journal the job only if it's enqueued, its acknowledgement waits for the journal ID:
+		qj.journaled = make(chan uint64, 1)
+	}
+	select {
+	case wp.jobsCh <- qj:
+		if qj.journaled != nil {
+			qj.journaled <- wp.journalAdd(job).id
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// EnqueueCtx is like [WorkerPool.Enqueue], but it gives up if ctx is cancelled before the job can be enqueued,
+// in which case ctx.Err() is returned.
+func (wp *WorkerPool[Job]) EnqueueCtx(ctx context.Context, job Job) error {
+	if err := ctx.Err(); err != nil {
+		return err // Don't journal a job that would be rejected
+	}
+	if err := wp.waitParkRoom(ctx); err != nil {
+		return err
+	}
+	qj := wp.journalAdd(job)
+	select {
+	case wp.jobsCh <- qj:
+		return nil
+	case <-ctx.Done():
+		wp.journalAck(qj) // Not enqueued, must not be replayed
+		return ctx.Err()
+	}
+}
+
+// QueueLen returns the number of jobs waiting in the queue for a free worker
+// (including jobs waiting for a running job of the same key to finish, see MaxPerKey).
+func (wp *WorkerPool[Job]) QueueLen() int {
+	wp.keysMu.Lock()
+	parked := wp.parked
+	wp.keysMu.Unlock()
+
+	return len(wp.jobsCh) + parked
+}
+
+// ActiveWorkers returns the number of workers currently handling a job.
+func (wp *WorkerPool[Job]) ActiveWorkers() int {
+	return int(wp.activeWorkers.Load())
 }
 
 // Wait signals the end of jobs, and blocks until all enqueued jobs have been processed.
+// If PanicPolicy is PanicRepanic and a job handler panicked, Wait panics with the first recovered *[PanicError].
 // Note: a worker pool is re-startable, but [WorkerPool.Wait] must be called and only once after a [WorkerPool.Start] call.
 func (wp *WorkerPool[Job]) Wait() {
-	// First close the jobs channel (no more jobs can be enqueued)...
+	// No more workers can be added...
+	wp.workersMu.Lock()
+	wp.waiting = true
+	wp.workersMu.Unlock()
+	// ... wait for replaying journaled jobs, then close the jobs channel (no more jobs can be enqueued)...
+	wp.replayWg.Wait()
 	close(wp.jobsCh)
 	// ... and wait for the workers to end.
 	wp.workersWg.Wait()
-	// Now we can close the results channel, which will end the result gathering goroutine.
+	// Now we can close the results channel, which will end the result gathering goroutine...
 	close(wp.resultsCh)
+	// ... which we wait for, so AbortRequested reports all results.
+	wp.handleResultWg.Wait()
+
+	if wp.Journal != nil {
+		if err := wp.Journal.finish(); err != nil {
+			wp.journalError(fmt.Errorf("failed to truncate journal: %w", err))
+		}
+	}
+
+	if wp.firstPanic != nil {
+		panic(wp.firstPanic)
+	}
 }
+
+// PanicPolicy tells how a worker pool handles panics of job handlers.
+type PanicPolicy int
+
+const (
+	// PanicPropagate tells not to recover panics: a panicking job handler crashes the process.
+	PanicPropagate PanicPolicy = iota
+
+	// PanicAbort tells to recover panics and request abortion (as if the job handler returned true).
+	PanicAbort
+
+	// PanicContinue tells to recover panics and continue processing jobs.
+	PanicContinue
+
+	// PanicRepanic tells to recover panics, continue processing jobs,
+	// and panic in [WorkerPool.Wait] (after all jobs have been processed) with the first recovered *[PanicError].
+	PanicRepanic
+)
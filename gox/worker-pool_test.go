@@ -0,0 +1,651 @@
+package gox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	var sum atomic.Int64
+	wp := &WorkerPool[int]{
+		WorkersCount: 4,
+		HandleJob: func(job int) bool {
+			sum.Add(int64(job))
+			return job == 10
+		},
+	}
+
+	for round := range 2 { // Pool is re-startable
+		sum.Store(0)
+		wp.Start()
+		for i := range 10 + round {
+			wp.Enqueue(i)
+		}
+		wp.Wait()
+
+		if got := sum.Load(); got != int64((9+round)*(10+round)/2) {
+			t.Errorf("[round %d] Expected sum %d, got: %d", round, (9+round)*(10+round)/2, got)
+		}
+		if got := wp.AbortRequested(); got != (round == 1) {
+			t.Errorf("[round %d] Expected abort requested %t, got: %t", round, round == 1, got)
+		}
+	}
+}
+
+func TestCtxWorkerPool(t *testing.T) {
+	errTest := errors.New("test")
+
+	var handled atomic.Int64
+	wp := &CtxWorkerPool[int]{
+		WorkersCount: 4,
+		HandleJob: func(ctx context.Context, job int) error {
+			handled.Add(1)
+			if job == 5 {
+				return errTest
+			}
+			return nil
+		},
+	}
+
+	// No errors:
+	wp.Start(context.Background())
+	for i := range 5 {
+		if err := wp.Enqueue(i); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if err := wp.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := handled.Load(); got != 5 {
+		t.Errorf("Expected %d handled jobs, got: %d", 5, got)
+	}
+
+	// First error aborts the pool:
+	wp.Start(context.Background())
+	var enqueueErr error
+	for i := range 100 {
+		if enqueueErr = wp.Enqueue(i); enqueueErr != nil {
+			break
+		}
+	}
+	if !errors.Is(enqueueErr, ErrWorkerPoolAborting) || !errors.Is(enqueueErr, errTest) {
+		t.Errorf("Expected aborting error, got: %v", enqueueErr)
+	}
+	if wp.Context().Err() == nil {
+		t.Errorf("Expected cancelled context")
+	}
+	if err := wp.Wait(); err != errTest {
+		t.Errorf("Expected %v, got: %v", errTest, err)
+	}
+
+	// Cancelled parent context:
+	ctx, cancel := context.WithCancel(context.Background())
+	wp.Start(ctx)
+	cancel()
+	if err := wp.Enqueue(1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v, got: %v", context.Canceled, err)
+	}
+	if err := wp.Wait(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Joined errors:
+	wp.JoinErrors = true
+	wp.HandleJob = func(ctx context.Context, job int) error { return errTest }
+	wp.Start(context.Background())
+	wp.Enqueue(1)
+	if err := wp.Wait(); !errors.Is(err, errTest) {
+		t.Errorf("Expected %v, got: %v", errTest, err)
+	}
+}
+
+func TestResultWorkerPool(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		wp := &ResultWorkerPool[int, string]{
+			WorkersCount: 4,
+			HandleJob: func(job int) string {
+				time.Sleep(time.Duration(job%3) * time.Millisecond) // Finish out of order
+				return strconv.Itoa(job)
+			},
+			Ordered: ordered,
+		}
+
+		var exp []string
+		wp.Start()
+		go func() {
+			for i := range 20 {
+				wp.Enqueue(i)
+			}
+			wp.Wait()
+		}()
+		for i := range 20 {
+			exp = append(exp, strconv.Itoa(i))
+		}
+
+		results := slices.Collect(wp.All())
+		if !ordered {
+			slices.SortFunc(results, func(a, b string) int {
+				x, _ := strconv.Atoi(a)
+				y, _ := strconv.Atoi(b)
+				return x - y
+			})
+		}
+		if !slices.Equal(results, exp) {
+			t.Errorf("[ordered: %t] Expected %v, got: %v", ordered, exp, results)
+		}
+	}
+
+	// Stopping iteration early must not block the pool:
+	wp := &ResultWorkerPool[int, int]{HandleJob: func(job int) int { return job }, Ordered: true}
+	wp.Start()
+	go func() {
+		for i := range 10 {
+			wp.Enqueue(i)
+		}
+		wp.Wait()
+	}()
+	for result := range wp.All() {
+		if result != 0 {
+			t.Errorf("Expected %d, got: %d", 0, result)
+		}
+		break
+	}
+}
+
+func TestWorkerPoolQueue(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wp := &WorkerPool[int]{
+		WorkersCount:  1,
+		QueueCapacity: 2,
+		HandleJob: func(job int) bool {
+			started <- struct{}{}
+			<-release
+			return false
+		},
+	}
+
+	wp.Start()
+	wp.Enqueue(0)
+	<-started // Worker is busy with job 0
+	if got := wp.ActiveWorkers(); got != 1 {
+		t.Errorf("Expected %d active workers, got: %d", 1, got)
+	}
+
+	if !wp.TryEnqueue(1) || !wp.TryEnqueue(2) {
+		t.Errorf("Expected room in queue")
+	}
+	if wp.TryEnqueue(3) {
+		t.Errorf("Expected full queue")
+	}
+	if got := wp.QueueLen(); got != 2 {
+		t.Errorf("Expected queue length %d, got: %d", 2, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := wp.EnqueueCtx(ctx, 3); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got: %v", context.DeadlineExceeded, err)
+	}
+
+	go func() {
+		for range started {
+		}
+	}()
+	close(release)
+	if err := wp.EnqueueCtx(context.Background(), 3); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	wp.Wait()
+	close(started)
+
+	if got := wp.ActiveWorkers(); got != 0 {
+		t.Errorf("Expected %d active workers, got: %d", 0, got)
+	}
+}
+
+func TestWorkerPoolPanic(t *testing.T) {
+	errTest := errors.New("test")
+
+	for _, policy := range []PanicPolicy{PanicAbort, PanicContinue, PanicRepanic} {
+		var (
+			handled  atomic.Int64
+			panicJob atomic.Int64
+			panicErr atomic.Pointer[PanicError]
+		)
+		wp := &WorkerPool[int]{
+			WorkersCount: 2,
+			HandleJob: func(job int) bool {
+				handled.Add(1)
+				if job == 3 {
+					panic(errTest)
+				}
+				return false
+			},
+			PanicPolicy: policy,
+			OnPanic: func(job int, err *PanicError) {
+				panicJob.Store(int64(job))
+				panicErr.Store(err)
+			},
+		}
+
+		wp.Start()
+		for i := range 10 {
+			wp.Enqueue(i)
+		}
+		recovered := Protect(wp.Wait)
+
+		if got := handled.Load(); got != 10 {
+			t.Errorf("[policy %d] Expected %d handled jobs, got: %d", policy, 10, got)
+		}
+		if got := panicJob.Load(); got != 3 {
+			t.Errorf("[policy %d] Expected panicking job %d, got: %d", policy, 3, got)
+		}
+		pe := panicErr.Load()
+		if pe == nil || !errors.Is(pe, errTest) || !strings.Contains(string(pe.Stack), "TestWorkerPoolPanic") {
+			t.Errorf("[policy %d] Expected panic error with stack, got: %v", policy, pe)
+		}
+		if got := wp.AbortRequested(); got != (policy == PanicAbort) {
+			t.Errorf("[policy %d] Expected abort requested %t, got: %t", policy, policy == PanicAbort, got)
+		}
+		if got := recovered != nil; got != (policy == PanicRepanic) {
+			t.Errorf("[policy %d] Expected re-panic %t, got: %v", policy, policy == PanicRepanic, recovered)
+		}
+		if policy == PanicRepanic && !errors.Is(recovered, errTest) {
+			t.Errorf("[policy %d] Expected re-panic with %v, got: %v", policy, errTest, recovered)
+		}
+	}
+}
+
+func TestWorkerPoolSetWorkers(t *testing.T) {
+	const jobs = 200
+	var (
+		handled   [jobs]atomic.Int64
+		active    atomic.Int64
+		maxActive atomic.Int64
+	)
+	wp := &WorkerPool[int]{
+		WorkersCount: 1,
+		HandleJob: func(job int) bool {
+			n := active.Add(1)
+			for m := maxActive.Load(); n > m && !maxActive.CompareAndSwap(m, n); m = maxActive.Load() {
+			}
+			time.Sleep(100 * time.Microsecond)
+			active.Add(-1)
+			handled[job].Add(1)
+			return false
+		},
+	}
+
+	wp.Start()
+	for i := range jobs {
+		switch i {
+		case 50:
+			wp.SetWorkers(8)
+		case 100:
+			wp.SetWorkers(2)
+		case 150:
+			wp.SetWorkers(0) // Minimum 1
+		}
+		wp.Enqueue(i)
+	}
+	if got := wp.Workers(); got != 1 {
+		t.Errorf("Expected %d workers, got: %d", 1, got)
+	}
+	wp.Wait()
+
+	for i := range handled {
+		if got := handled[i].Load(); got != 1 {
+			t.Errorf("Expected job %d to be handled once, got: %d", i, got)
+		}
+	}
+	if got := maxActive.Load(); got < 2 || got > 8 {
+		t.Errorf("Expected max active workers between %d and %d, got: %d", 2, 8, got)
+	}
+
+	// Autoscale:
+	var samples atomic.Int64
+	wp.QueueCapacity = 10
+	wp.Autoscale = func(sample WorkerPoolSample) int {
+		samples.Add(1)
+		if sample.Latency <= 0 {
+			t.Errorf("Expected positive latency, got: %v", sample.Latency)
+		}
+		return 4
+	}
+	wp.Start()
+	for i := range 20 {
+		wp.Enqueue(i)
+	}
+	if got := wp.Workers(); got != 4 {
+		t.Errorf("Expected %d workers, got: %d", 4, got)
+	}
+	wp.Wait()
+	if got := samples.Load(); got != 20 {
+		t.Errorf("Expected %d samples, got: %d", 20, got)
+	}
+}
+
+func TestWorkerPoolLimits(t *testing.T) {
+	// Per-key concurrency:
+	var (
+		mu        sync.Mutex
+		running   = map[string]int{}
+		maxPerKey = map[string]int{}
+		handled   atomic.Int64
+	)
+	release := make(chan struct{})
+	wp := &WorkerPool[string]{
+		WorkersCount: 4,
+		HandleJob: func(job string) bool {
+			mu.Lock()
+			running[job]++
+			maxPerKey[job] = max(maxPerKey[job], running[job])
+			mu.Unlock()
+			if job == "slow" {
+				<-release
+			}
+			mu.Lock()
+			running[job]--
+			mu.Unlock()
+			handled.Add(1)
+			return false
+		},
+		JobKey:    func(job string) any { return job },
+		MaxPerKey: 2,
+	}
+
+	wp.Start()
+	for range 5 {
+		wp.Enqueue("slow")
+	}
+	for range 20 {
+		wp.Enqueue("fast") // Must not be blocked by the saturated "slow" key
+	}
+	for handled.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wp.Wait()
+
+	if got := handled.Load(); got != 25 {
+		t.Errorf("Expected %d handled jobs, got: %d", 25, got)
+	}
+	if got := maxPerKey["slow"]; got != 2 {
+		t.Errorf("Expected max %d concurrent jobs per key, got: %d", 2, got)
+	}
+	if got := maxPerKey["fast"]; got > 2 {
+		t.Errorf("Expected max %d concurrent jobs per key, got: %d", 2, got)
+	}
+	if len(wp.keys) != 0 {
+		t.Errorf("Expected no key states, got: %v", wp.keys)
+	}
+
+	// Jobs waiting for their key must be subject to backpressure:
+	release = make(chan struct{})
+	wp = &WorkerPool[string]{
+		WorkersCount: 2,
+		HandleJob:    func(job string) bool { <-release; return false },
+		JobKey:       func(job string) any { return job },
+		MaxPerKey:    1,
+		MaxWaiting:   2,
+	}
+	wp.Start()
+	for range 3 { // 1 running, 2 waiting for their key
+		wp.Enqueue("key")
+	}
+	for wp.QueueLen() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if wp.TryEnqueue("key") || wp.TryEnqueue("other") {
+		t.Errorf("Expected rejected job")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := wp.EnqueueCtx(ctx, "other"); err != context.DeadlineExceeded {
+		t.Errorf("Expected error %v, got: %v", context.DeadlineExceeded, err)
+	}
+	enqueued := make(chan struct{})
+	go func() {
+		wp.Enqueue("other") // Must block until a waiting job is started
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		t.Errorf("Expected blocked Enqueue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-enqueued
+	wp.Wait()
+	if got := wp.QueueLen(); got != 0 {
+		t.Errorf("Expected queue length %d, got: %d", 0, got)
+	}
+
+	// Rate:
+	wp = &WorkerPool[string]{
+		WorkersCount: 4,
+		HandleJob:    func(job string) bool { return false },
+		Rate:         200,
+		Burst:        2,
+	}
+	start := time.Now()
+	wp.Start()
+	for range 12 {
+		wp.Enqueue("")
+	}
+	wp.Wait()
+	// 2 jobs start immediately, the remaining 10 take 5ms each:
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("Expected rate limited processing, took: %v", elapsed)
+	}
+}
+
+func TestPriorityWorkerPool(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	wp := &PriorityWorkerPool[string]{
+		HandleJob: func(job string) bool {
+			if job == "block" {
+				close(started)
+				<-release
+				return false
+			}
+			mu.Lock()
+			order = append(order, job)
+			mu.Unlock()
+			return job == "abort"
+		},
+		Priority: func(job string) int { return len(job) },
+	}
+
+	wp.Start()
+	wp.Enqueue("block")
+	<-started // The single worker is busy, the rest is queued
+	wp.EnqueuePriority("low-1", 0)
+	wp.Enqueue("abort")
+	wp.EnqueuePriority("low-2", 0)
+	wp.EnqueuePriority("high", 10)
+	if got := wp.QueueLen(); got != 4 {
+		t.Errorf("Expected queue length %d, got: %d", 4, got)
+	}
+	close(release)
+	wp.Wait()
+
+	if exp := []string{"high", "abort", "low-1", "low-2"}; !slices.Equal(order, exp) {
+		t.Errorf("Expected %v, got: %v", exp, order)
+	}
+	if !wp.AbortRequested() {
+		t.Errorf("Expected abort requested")
+	}
+
+	// Aging:
+	order = nil
+	wp.AgingPeriod = time.Millisecond
+	started, release = make(chan struct{}), make(chan struct{})
+	wp.Start()
+	wp.Enqueue("block")
+	<-started
+	wp.EnqueuePriority("old", 0)
+	time.Sleep(20 * time.Millisecond) // "old" ages 20 priority levels
+	wp.EnqueuePriority("new", 10)
+	close(release)
+	wp.Wait()
+
+	if exp := []string{"old", "new"}; !slices.Equal(order, exp) {
+		t.Errorf("Expected %v, got: %v", exp, order)
+	}
+
+	// Enqueuing after Wait must not lose the job silently:
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected panic")
+			}
+		}()
+		wp.Enqueue("late")
+	}()
+}
+
+func TestWorkerPoolJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.journal")
+
+	// Simulate a previous run that crashed: jobs enqueued but only some acknowledged.
+	j, err := OpenJobJournal[string](path, JSONCodec)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	for _, job := range []string{"a", "b", "c"} {
+		id, err := j.add(job)
+		if err != nil {
+			t.Fatalf("Failed to add job: %v", err)
+		}
+		if job == "b" {
+			j.ack(id)
+		}
+	}
+	j.Close()
+
+	// Partial record of an interrupted write must be ignored:
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open journal file: %v", err)
+	}
+	f.Write([]byte{journalRecordAdd, 9, 100, 'x'})
+	f.Close()
+
+	j, err = OpenJobJournal[string](path, JSONCodec)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.Close()
+	if got := j.Pending(); got != 2 {
+		t.Errorf("Expected %d pending jobs, got: %d", 2, got)
+	}
+
+	var (
+		mu      sync.Mutex
+		handled []string
+	)
+	wp := &WorkerPool[string]{
+		HandleJob: func(job string) bool {
+			mu.Lock()
+			handled = append(handled, job)
+			mu.Unlock()
+			return false
+		},
+		Journal:        j,
+		OnJournalError: func(err error) { t.Errorf("Unexpected journal error: %v", err) },
+	}
+	wp.Start()
+	wp.Enqueue("d")
+	wp.Wait()
+
+	slices.Sort(handled)
+	if exp := []string{"a", "c", "d"}; !slices.Equal(handled, exp) {
+		t.Errorf("Expected handled %v, got: %v", exp, handled)
+	}
+	if got := j.Pending(); got != 0 {
+		t.Errorf("Expected %d pending jobs, got: %d", 0, got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Errorf("Expected truncated journal, got: %v, %v", fi, err)
+	}
+
+	// Restarting the pool must not replay anything:
+	handled = nil
+	wp.Start()
+	wp.Wait()
+	if len(handled) != 0 {
+		t.Errorf("Expected no handled jobs, got: %v", handled)
+	}
+
+	// Jobs certain to be rejected must not be journaled:
+	release := make(chan struct{})
+	wp = &WorkerPool[string]{
+		WorkersCount:   1,
+		QueueCapacity:  1,
+		HandleJob:      func(job string) bool { <-release; return false },
+		Journal:        j,
+		OnJournalError: func(err error) { t.Errorf("Unexpected journal error: %v", err) },
+	}
+	wp.Start()
+	wp.Enqueue("e") // Taken by the worker
+	wp.Enqueue("f") // Fills the queue (can't be enqueued until the worker took "e")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat journal: %v", err)
+	}
+	if wp.TryEnqueue("g") {
+		t.Errorf("Expected TryEnqueue to fail on full queue")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := wp.EnqueueCtx(ctx, "h"); err != context.Canceled {
+		t.Errorf("Expected error %v, got: %v", context.Canceled, err)
+	}
+	if fi2, err := os.Stat(path); err != nil || fi2.Size() != fi.Size() {
+		t.Errorf("Expected unchanged journal size %d, got: %v, %v", fi.Size(), fi2, err)
+	}
+	if got := j.Pending(); got != 2 {
+		t.Errorf("Expected %d pending jobs, got: %d", 2, got)
+	}
+	close(release)
+	wp.Wait()
+
+	// Same with an unbuffered queue:
+	release = make(chan struct{})
+	wp.QueueCapacity = 0
+	wp.Start()
+	wp.Enqueue("e") // Taken by the worker
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatalf("Failed to stat journal: %v", err)
+	}
+	if wp.TryEnqueue("g") {
+		t.Errorf("Expected TryEnqueue to fail with busy worker")
+	}
+	if fi2, err := os.Stat(path); err != nil || fi2.Size() != fi.Size() {
+		t.Errorf("Expected unchanged journal size %d, got: %v, %v", fi.Size(), fi2, err)
+	}
+	close(release)
+	for !wp.TryEnqueue("g") { // Accepted once the worker is free
+		time.Sleep(time.Millisecond)
+	}
+	wp.Wait()
+	if got := j.Pending(); got != 0 {
+		t.Errorf("Expected %d pending jobs, got: %d", 0, got)
+	}
+}
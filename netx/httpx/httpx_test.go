@@ -3,10 +3,16 @@ package httpx
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/icza/gox/gox"
 )
 
 func TestClientIP(t *testing.T) {
@@ -176,3 +182,82 @@ func TestGetURL(t *testing.T) {
 		}
 	}
 }
+
+func TestOpCacheHandler(t *testing.T) {
+	evictor := gox.NewEvictor(nil)
+	users := gox.NewOpCache[int, string](gox.OpCacheConfig{Name: "users", ResultExpiration: time.Minute, Evictor: evictor})
+	defer users.Close()
+	reports := gox.NewOpCache[string, []byte](gox.OpCacheConfig{Name: "reports", ResultExpiration: time.Minute, Evictor: evictor})
+	defer reports.Close()
+
+	users.Set(1, "bob", nil)
+	users.Set(2, "", errors.New("not found"))
+	reports.Set("daily", []byte("report"), nil)
+
+	// Unnamed and duplicate-named caches must not be served:
+	for _, name := range []string{"", "", "dup", "dup"} {
+		c := gox.NewOpCache[int, int](gox.OpCacheConfig{Name: name, ResultExpiration: time.Minute, Evictor: evictor})
+		defer c.Close()
+		c.Set(1, 1, nil)
+	}
+
+	h := &OpCacheHandler{Caches: evictor.OpCaches}
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/debug/opcaches?format=json")
+	var list []opCacheView
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "reports" || list[1].Name != "users" || list[1].Stats.Entries != 2 {
+		t.Errorf("Unexpected list: %+v", list)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `"entries":2`) || strings.Contains(body, `"Entries"`) {
+		t.Errorf("Expected camel case stats: %s", body)
+	}
+	for _, target := range []string{"/debug/opcaches?cache=&action=clear", "/debug/opcaches?cache=dup&action=clear"} {
+		if rec = serve(http.MethodPost, target); rec.Code != http.StatusNotFound {
+			t.Errorf("[%s] Expected status %d, got: %d", target, http.StatusNotFound, rec.Code)
+		}
+	}
+
+	rec = serve(http.MethodGet, "/debug/opcaches?format=json&cache=users")
+	var view opCacheView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(view.Entries) != 2 || view.Entries[0].Key != "1" || view.Entries[1].Err != "not found" {
+		t.Errorf("Unexpected view: %+v", view)
+	}
+
+	rec = serve(http.MethodGet, "/debug/opcaches?cache=users")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(rec.Body.String(), "not found") {
+		t.Errorf("Unexpected HTML response (%s): %s", ct, rec.Body)
+	}
+
+	if rec = serve(http.MethodGet, "/debug/opcaches?cache=users&action=clear"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got: %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if rec = serve(http.MethodGet, "/debug/opcaches?cache=missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got: %d", http.StatusNotFound, rec.Code)
+	}
+
+	if rec = serve(http.MethodPost, "/debug/opcaches?cache=users&action=remove&key=1"); rec.Code != http.StatusSeeOther {
+		t.Errorf("Expected status %d, got: %d", http.StatusSeeOther, rec.Code)
+	}
+	if loc, exp := rec.Header().Get("Location"), "/debug/opcaches?cache=users"; loc != exp {
+		t.Errorf("Expected location %q, got: %q", exp, loc)
+	}
+	if _, ok := users.Peek(1); ok {
+		t.Errorf("Expected removed")
+	}
+
+	rec = serve(http.MethodPost, "/debug/opcaches?cache=reports&action=clear&format=json")
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"removed":1}` || reports.Len() != 0 {
+		t.Errorf("Unexpected response: %s", body)
+	}
+}
@@ -0,0 +1,203 @@
+package httpx
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/icza/gox/gox"
+)
+
+// DefaultOpCacheHandlerMaxKeys is the default max number of entries listed by OpCacheHandler.
+const DefaultOpCacheHandlerMaxKeys = 1000
+
+// OpCacheHandler is an [http.Handler] serving debug views of OpCaches, similar to net/http/pprof.
+// The zero value is ready for use, serving the OpCaches registered in the global evictor
+// (see [gox.RegisteredOpCaches]), so name your caches (see [gox.OpCacheConfig.Name]).
+//
+// Requests are served based on query parameters (so the handler can be mounted under any path):
+//
+//   - GET lists the caches with their stats.
+//   - GET with cache=name shows the stats and entries (keys with expiration times) of the named cache.
+//   - POST with cache=name&action=remove&key=k removes the entry of the given key (see [gox.OpCacheInspector]).
+//   - POST with cache=name&action=clear removes all entries of the named cache.
+//
+// Responses are HTML, or JSON if the format=json query parameter is given
+// or the Accept header prefers application/json.
+// Actions respond with 405 Method Not Allowed unless sent with POST.
+//
+// Caches are identified by their names, so unnamed caches and caches whose name is not unique are not served.
+//
+// Actions modify the caches, so make sure the handler is only accessible to authorized users.
+type OpCacheHandler struct {
+	// Caches returns the caches to serve. If nil, [gox.RegisteredOpCaches] is used.
+	Caches func() []gox.OpCacheInspector
+
+	// MaxKeys is the max number of entries listed for a cache.
+	// If 0, DefaultOpCacheHandlerMaxKeys is used.
+	MaxKeys int
+}
+
+// opCacheView is the view model of a cache.
+type opCacheView struct {
+	Name    string                 `json:"name"`
+	Stats   gox.OpCacheStats       `json:"stats"`
+	Entries []gox.OpCacheEntryInfo `json:"entries,omitempty"`
+
+	Truncated bool `json:"truncated,omitempty"` // Tells if Entries were truncated due to MaxKeys
+}
+
+// ServeHTTP implements [http.Handler].
+func (h *OpCacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	asJSON := q.Get("format") == "json" || strings.HasPrefix(r.Header.Get("Accept"), "application/json")
+
+	caches := gox.RegisteredOpCaches
+	if h.Caches != nil {
+		caches = h.Caches
+	}
+
+	name, action := q.Get("cache"), q.Get("action")
+
+	ocis := uniquelyNamed(caches())
+
+	var oci gox.OpCacheInspector
+	if q.Has("cache") {
+		idx := slices.IndexFunc(ocis, func(c gox.OpCacheInspector) bool { return c.Name() == name })
+		if idx < 0 {
+			http.Error(w, "cache not found", http.StatusNotFound)
+			return
+		}
+		oci = ocis[idx]
+	}
+
+	switch {
+	case action != "":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if oci == nil {
+			http.Error(w, "missing cache", http.StatusBadRequest)
+			return
+		}
+		h.serveAction(w, r, oci, action, asJSON)
+		return
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data any
+	if oci == nil {
+		var views []opCacheView
+		for _, c := range ocis {
+			views = append(views, opCacheView{Name: c.Name(), Stats: c.Stats()})
+		}
+		data = views
+	} else {
+		view := opCacheView{Name: oci.Name(), Stats: oci.Stats(), Entries: oci.EntryInfos()}
+		slices.SortFunc(view.Entries, func(a, b gox.OpCacheEntryInfo) int { return strings.Compare(a.Key, b.Key) })
+		if maxKeys := gox.Coalesce(h.MaxKeys, DefaultOpCacheHandlerMaxKeys); len(view.Entries) > maxKeys {
+			view.Entries, view.Truncated = view.Entries[:maxKeys], true
+		}
+		data = view
+	}
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl := opCacheListTmpl
+	if oci != nil {
+		tmpl = opCacheTmpl
+	}
+	tmpl.Execute(w, data)
+}
+
+// uniquelyNamed returns the caches having a non-empty, unique name (preserving their order).
+func uniquelyNamed(ocis []gox.OpCacheInspector) (named []gox.OpCacheInspector) {
+	counts := map[string]int{}
+	for _, c := range ocis {
+		counts[c.Name()]++
+	}
+	for _, c := range ocis {
+		if name := c.Name(); name != "" && counts[name] == 1 {
+			named = append(named, c)
+		}
+	}
+	return
+}
+
+// serveAction executes the given action on oci.
+func (h *OpCacheHandler) serveAction(w http.ResponseWriter, r *http.Request, oci gox.OpCacheInspector, action string, asJSON bool) {
+	var removed int
+	switch action {
+	case "remove":
+		removed = oci.RemoveKeyString(r.URL.Query().Get("key"))
+	case "clear":
+		removed = oci.Stats().Entries
+		oci.Clear()
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"removed": removed})
+		return
+	}
+
+	// Redirect back to the cache's page
+	// (using the request path, a relative "?cache=" would be resolved against the path's directory):
+	http.Redirect(w, r, r.URL.Path+"?cache="+url.QueryEscape(oci.Name()), http.StatusSeeOther)
+}
+
+var opCacheTmplFuncs = template.FuncMap{
+	"fmtTime": func(t time.Time) string { return t.Format(time.RFC3339) },
+}
+
+const opCacheStatsTmpl = `{{define "stats"}}<td>{{.Entries}}</td><td>{{.Weight}}</td><td>{{.InFlight}}</td>` +
+	`<td>{{.Hits}}</td><td>{{.GraceHits}}</td><td>{{.Misses}}</td><td>{{.Reloads}}</td>` +
+	`<td>{{.DiscardedErrors}}</td><td>{{.Evictions}}</td>{{end}}` +
+	`{{define "statsHead"}}<th>Entries</th><th>Weight</th><th>In flight</th><th>Hits</th><th>Grace hits</th>` +
+	`<th>Misses</th><th>Reloads</th><th>Discarded errors</th><th>Evictions</th>{{end}}`
+
+var opCacheListTmpl = template.Must(template.New("").Funcs(opCacheTmplFuncs).Parse(opCacheStatsTmpl + `<!DOCTYPE html>
+<html><head><title>OpCaches</title></head><body>
+<h1>OpCaches</h1>
+<table border="1">
+<tr><th>Name</th>{{template "statsHead"}}</tr>
+{{range .}}<tr><td><a href="?cache={{.Name}}">{{.Name}}</a></td>{{template "stats" .Stats}}</tr>
+{{end}}</table>
+</body></html>
+`))
+
+var opCacheTmpl = template.Must(template.New("").Funcs(opCacheTmplFuncs).Parse(opCacheStatsTmpl + `<!DOCTYPE html>
+<html><head><title>OpCache {{.Name}}</title></head><body>
+<p><a href="?">All caches</a></p>
+<h1>OpCache {{.Name}}</h1>
+<table border="1">
+<tr>{{template "statsHead"}}</tr>
+<tr>{{template "stats" .Stats}}</tr>
+</table>
+<form method="post" action="?cache={{.Name}}&amp;action=clear"><button type="submit">Clear</button></form>
+<h2>Entries</h2>
+{{if .Truncated}}<p>Entries are truncated.</p>{{end}}
+<table border="1">
+<tr><th>Key</th><th>Error</th><th>Expires at</th><th>Grace expires at</th><th>Reloading</th><th></th></tr>
+{{range .Entries}}<tr><td>{{.Key}}</td><td>{{.Err}}</td><td>{{fmtTime .ExpiresAt}}</td><td>{{fmtTime .GraceExpiresAt}}</td>
+<td>{{.Reloading}}</td><td><form method="post" action="?cache={{$.Name}}&amp;action=remove&amp;key={{.Key}}"><button type="submit">Remove</button></form></td></tr>
+{{end}}</table>
+</body></html>
+`))